@@ -21,6 +21,7 @@ import (
 
 	"github.com/maksim-paskal/gitlab-registry-cleaner/internal"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/api"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
 	logrushooksentry "github.com/maksim-paskal/logrus-hook-sentry"
 	log "github.com/sirupsen/logrus"
 )
@@ -36,6 +37,34 @@ const gracefulShutdownTimeout = 5 * time.Second
 func main() {
 	flag.Parse()
 
+	// first argument is mode, for example: apply -plan plan.json
+	if flag.NArg() > 0 {
+		mode := flag.Arg(0)
+		args := flag.Args()[1:]
+
+		// inventory export -inventory inventory.ndjson is the same as export mode
+		if mode == "inventory" {
+			if len(args) == 0 || args[0] != config.ModeExport {
+				log.Fatalf("unknown inventory command %v, use inventory export", args)
+			}
+
+			mode = config.ModeExport
+			args = args[1:]
+		}
+
+		if err := flag.CommandLine.Parse(args); err != nil {
+			log.WithError(err).Fatal()
+		}
+
+		if flag.NArg() > 0 {
+			log.Fatalf("unknown arguments %v", flag.Args())
+		}
+
+		if err := flag.Set("mode", mode); err != nil {
+			log.WithError(err).Fatal()
+		}
+	}
+
 	if *version {
 		fmt.Println(api.GetVersion()) //nolint:forbidigo
 		os.Exit(0)
@@ -72,6 +101,10 @@ func main() {
 		time.Sleep(gracefulShutdownTimeout)
 	})
 
+	if err := config.Load(); err != nil {
+		log.WithError(err).Fatal()
+	}
+
 	internal.Init()
 
 	if err := internal.Run(ctx); err != nil {
@@ -14,17 +14,30 @@ package internal
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/api"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/classifier"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/gitlab"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/inventory"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/kubernetes"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/metrics"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/plan"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/providers/docker"
-	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/providers/s3"
+	gitlabprovider "github.com/maksim-paskal/gitlab-registry-cleaner/pkg/providers/gitlab"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/providers/layout"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/ratelimit"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/azure"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/filesystem"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/gcs"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/s3"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/utils"
 	"github.com/pkg/errors"
@@ -32,109 +45,98 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	defaultNotDeleteDays    = 10
-	defaultMinNotDeleteTags = 3
-)
-
-var (
-	provider                  = flag.String("provider", "docker", "")
-	dryRun                    = flag.Bool("dry-run", false, "")
-	snapshotEnabled           = flag.Bool("snapshots", false, "enable snapshot clearing")
-	snapshotRepositoryPattern = flag.String("snapshot.repository", utils.GetEnv("SNAPSHOT_REPOSITORY", `^devops/docker/mysql-.+$`), "") //nolint:lll
-	snapshotTagPattern        = flag.String("snapshot.tag", utils.GetEnv("SNAPSHOT_TAG", `^(\d{8})-snap$`), "")
-	snapshotNotDeleteDays     = flag.Float64("snapshot.daysNotDelete", defaultNotDeleteDays, "")
-	minNotDeleteSnapshotTags  = flag.Int("snapshot.minTags", defaultMinNotDeleteTags, "")
-	registryFilter            = flag.String("registry.filter", "", "")
-	releaseTagPattern         = flag.String("release.tag", utils.GetEnv("RELEASE_TAG", `^release-(\d{8}).*$`), "")
-	systemTagPattern          = flag.String("system.tag", utils.GetEnv("SYSTEM_TAG", `^(main|master)$`), "")
-	ignoreRepositoryPattern   = flag.String("ignoreTags", utils.GetEnv("IGNORE_TAGS", `^devops/docker$`), "")
-	releaseNotDeleteDays      = flag.Float64("release.daysNotDelete", defaultNotDeleteDays, "")
-	minNotDeleteReleaseTags   = flag.Int("release.minTags", defaultMinNotDeleteTags, "")
-	ciCheck                   = flag.Bool("ci.check", false, "check if release tag is valid")
-	ciTag                     = flag.String("ci.tag", os.Getenv("CI_COMMIT_REF_NAME"), "tag to check")
-	ciCommitDate              = flag.String("ci.commitDate", os.Getenv("CI_COMMIT_TIMESTAMP"), "commit date to check")
-)
-
 var releaseTagRegexp,
-	systemTagRegexp,
 	ignoreRepositoryRegexp,
 	snapshotRepositoryRegexp,
 	snapshotTagRegexp *regexp.Regexp
 
+// Init compiles config patterns, config must be validated before.
 func Init() {
-	releaseTagRegexp = regexp.MustCompile(*releaseTagPattern)
-	systemTagRegexp = regexp.MustCompile(*systemTagPattern)
-	ignoreRepositoryRegexp = regexp.MustCompile(*ignoreRepositoryPattern)
-	snapshotRepositoryRegexp = regexp.MustCompile(*snapshotRepositoryPattern)
-	snapshotTagRegexp = regexp.MustCompile(*snapshotTagPattern)
+	releaseTagRegexp = regexp.MustCompile(config.Get().Release.Tag)
+	ignoreRepositoryRegexp = regexp.MustCompile(config.Get().Registry.Ignore)
+	snapshotRepositoryRegexp = regexp.MustCompile(config.Get().Snapshot.Repository)
+	snapshotTagRegexp = regexp.MustCompile(config.Get().Snapshot.Tag)
+
+	ratelimit.Init()
 }
 
 // Run main logic.
 func Run(ctx context.Context) error { //nolint:funlen,cyclop
-	// Login to gitlab
-	if err := gitlab.Init(); err != nil {
-		log.Fatal(err)
-	}
-
-	if *ciCheck {
-		if err := api.CheckReleaseTag(releaseTagRegexp, *ciTag, *ciCommitDate); err != nil {
-			fmt.Printf("Tag %s is not valid:\n%s", *ciTag, err.Error()) //nolint:forbidigo
+	if ci := config.Get().CI; ci.Check {
+		if err := api.CheckReleaseTag(releaseTagRegexp, ci.Tag, ci.CommitDate); err != nil {
+			fmt.Printf("Tag %s is not valid:\n%s", ci.Tag, err.Error()) //nolint:forbidigo
 
 			os.Exit(1)
 		}
 
-		fmt.Printf("Tag %s is valid\n", *ciTag) //nolint:forbidigo
+		fmt.Printf("Tag %s is valid\n", ci.Tag) //nolint:forbidigo
 
 		return nil
 	}
 
-	var registry types.Provider
+	log.Infof("Starting %s %s...", filepath.Base(os.Args[0]), api.GetVersion())
 
-	switch *provider {
-	case "docker":
-		registry = &docker.Provider{}
-	case "s3":
-		registry = &s3.Provider{}
-	default:
-		return errors.Errorf("%s unknown provider", *provider)
+	registry, source, err := newProvider()
+	if err != nil {
+		return err
 	}
 
-	log.Infof("Starting %s %s...", filepath.Base(os.Args[0]), api.GetVersion())
-	log.Infof("Using %s provider...", *provider)
-
 	// Login to registry
-	if err := registry.Init(ctx, *dryRun); err != nil {
+	if err := registry.Init(ctx, config.Get().DryRun); err != nil {
 		return errors.Wrap(err, "can not init registry")
 	}
 
-	// get all docker repository
-	repositories, err := registry.Repositories(ctx, *registryFilter)
-	if err != nil {
-		return errors.Wrap(err, "can not list repositories")
+	if config.Get().Mode == config.ModeExport {
+		return exportInventory(ctx, registry, source)
 	}
 
-	log.Infof("repositories: %v", repositories)
+	var result *plan.Plan
 
-	tagsToDelete := make([]types.DeleteTagInput, 0)
+	// apply mode deletes tags from plan file
+	if config.Get().Mode == config.ModeApply {
+		result, err = loadPlan(ctx, registry)
+	} else {
+		result, err = createPlan(ctx, registry, source)
+	}
 
-	// get stalled docker tags
-	staledDockerTags, err := getStaleDockerTags(ctx, registry, repositories)
 	if err != nil {
-		return errors.Wrap(err, "can not get staled docker tags")
+		return errors.Wrap(err, "can not get plan")
 	}
 
-	tagsToDelete = append(tagsToDelete, staledDockerTags...)
+	if config.Get().Mode == config.ModePlan {
+		if err := result.Save(config.Get().Plan); err != nil {
+			return errors.Wrap(err, "can not save plan")
+		}
 
-	// get staled snapshot tags
-	if *snapshotEnabled {
-		tagsToDelete = append(tagsToDelete, getStaledSnashotsTags(ctx, registry, repositories)...)
+		log.Infof("plan saved to %s, tags to delete %d, tags to keep %d, uploads to delete %d, repositories to delete %d, unmapped repositories %d, moved repositories %d", //nolint:lll
+			config.Get().Plan,
+			len(result.Delete),
+			len(result.Keep),
+			len(result.Uploads),
+			len(result.Repositories),
+			len(result.Unmapped),
+			len(result.Moved),
+		)
+
+		return nil
 	}
 
+	// grace period of missing projects starts only when tags are deleted
+	if config.Get().Projects.Missing.Enabled {
+		if err := saveMissingProjects(result); err != nil {
+			return errors.Wrap(err, "can not save state of missing projects")
+		}
+	}
+
+	workers := config.Get().Registry.Workers
+
 	// delete tags from registry
-	for _, tag := range tagsToDelete {
+	utils.Parallel(workers, len(result.Delete), func(i int) {
+		tag := result.Delete[i]
+
 		metrics.TagsDeleted.Inc()
-		log.Infof("delete image=%s:%s reason=%s", tag.Repository, tag.Tag, tag.TagType.String())
+		metrics.TagsDeletedByType.WithLabelValues(tag.TagType.String()).Inc()
+		log.Infof("delete image=%s:%s reason=%s %s", tag.Repository, tag.Tag, tag.TagType.String(), tag.Reason)
 
 		// tag will be removed
 		err := registry.DeleteTag(ctx, tag)
@@ -142,6 +144,41 @@ func Run(ctx context.Context) error { //nolint:funlen,cyclop
 			metrics.TagsErrors.Inc()
 			log.WithError(err).Errorf("%s:%s reason=%s", tag.Repository, tag.Tag, tag.TagType.String())
 		}
+	})
+
+	// delete stale uploads
+	if uploadsProvider, ok := registry.(types.UploadsProvider); ok {
+		utils.Parallel(workers, len(result.Uploads), func(i int) {
+			upload := result.Uploads[i]
+
+			metrics.UploadsDeleted.Inc()
+			log.Infof("delete upload=%s/%s startedAt=%s %s",
+				upload.Repository,
+				upload.Upload,
+				upload.StartedAt.Format(time.RFC3339),
+				upload.Reason,
+			)
+
+			if err := uploadsProvider.DeleteUpload(ctx, upload); err != nil {
+				metrics.TagsErrors.Inc()
+				log.WithError(err).Errorf("%s/%s can not delete upload", upload.Repository, upload.Upload)
+			}
+		})
+	}
+
+	// delete repositories without tags and uploads
+	if repositoriesProvider, ok := registry.(types.RepositoriesProvider); ok {
+		utils.Parallel(workers, len(result.Repositories), func(i int) {
+			repository := result.Repositories[i]
+
+			metrics.RepositoriesDeleted.Inc()
+			log.Infof("delete repository=%s %s", repository.Repository, repository.Reason)
+
+			if err := repositoriesProvider.DeleteRepository(ctx, repository); err != nil {
+				metrics.TagsErrors.Inc()
+				log.WithError(err).Errorf("%s can not delete repository", repository.Repository)
+			}
+		})
 	}
 
 	// Run post commands in registry
@@ -164,10 +201,22 @@ func Run(ctx context.Context) error { //nolint:funlen,cyclop
 		return errors.Wrap(err, "can not get current errors tags")
 	}
 
-	log.Infof("tags deleted %s warnings %s errors %s",
+	tagsInUse := &dto.Metric{}
+	if err := metrics.TagsInUse.Write(tagsInUse); err != nil {
+		return errors.Wrap(err, "can not get current in use tags")
+	}
+
+	tagsSharedDigest := &dto.Metric{}
+	if err := metrics.TagsSharedDigest.Write(tagsSharedDigest); err != nil {
+		return errors.Wrap(err, "can not get current shared digest tags")
+	}
+
+	log.Infof("tags deleted %s warnings %s errors %s shared digest %s in use %s",
 		tagsDeleted.GetCounter().String(),
 		tagsWarnings.GetCounter().String(),
 		tagsErrors.GetCounter().String(),
+		tagsSharedDigest.GetCounter().String(),
+		tagsInUse.GetCounter().String(),
 	)
 
 	metrics.CompletionTime.SetToCurrentTime()
@@ -180,16 +229,201 @@ func Run(ctx context.Context) error { //nolint:funlen,cyclop
 	return nil
 }
 
-// get staled docker tags to delete from docker registry.
-func getStaleDockerTags(ctx context.Context, registry types.Provider, repositories []string) ([]types.DeleteTagInput, error) { //nolint:funlen,gocognit,lll,cyclop
+// create registry provider and source of gitlab projects, plan with inventory does not use network.
+func newProvider() (types.Provider, gitlab.Source, error) { //nolint:ireturn
+	provider := config.Get().Provider
+
+	if config.Get().Mode == config.ModePlan && len(config.Get().Inventory) > 0 {
+		result, err := loadInventory()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		log.Infof("Using inventory of %s provider created at %s...", provider, result.CreatedAt.String())
+
+		return inventory.NewProvider(result), result, nil
+	}
+
+	// Login to gitlab
+	if err := gitlab.Init(); err != nil {
+		return nil, nil, errors.Wrap(err, "can not init gitlab")
+	}
+
+	var registry types.Provider
+
+	switch provider {
+	case "docker":
+		registry = &docker.Provider{}
+	case "s3":
+		registry = &layout.Provider{Store: &s3.Store{}}
+	case "gcs":
+		registry = &layout.Provider{Store: &gcs.Store{}}
+	case "azure":
+		registry = &layout.Provider{Store: &azure.Store{}}
+	case "gitlab":
+		registry = &gitlabprovider.Provider{}
+	case "filesystem":
+		registry = &layout.Provider{Store: &filesystem.Store{}}
+	default:
+		return nil, nil, errors.Errorf("%s unknown provider", provider)
+	}
+
+	log.Infof("Using %s provider...", provider)
+
+	return registry, gitlab.API{}, nil
+}
+
+// time of plan, plan from inventory is created at time of inventory to be reproducible.
+func planTime(source gitlab.Source) time.Time {
+	if result, ok := source.(*inventory.Inventory); ok {
+		return result.CreatedAt.UTC()
+	}
+
+	return time.Now().UTC()
+}
+
+// create plan of tags to delete and to keep.
+func createPlan(ctx context.Context, registry types.Provider, source gitlab.Source) (*plan.Plan, error) {
+	// get all docker repository
+	repositories, err := registry.Repositories(ctx, config.Get().Registry.Filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not list repositories")
+	}
+
+	log.Infof("repositories: %v", repositories)
+
+	if _, ok := registry.(types.DetailsProvider); !ok && config.Get().Unknown.Enabled {
+		log.Warnf("%s provider has no tag details, unknown tags will not be deleted", config.Get().Provider)
+	}
+
+	if config.Get().Unknown.Enabled && len(config.Get().Branch.Tag) == 0 {
+		log.Warn("tags without branch are deleted as branch tags, set branch.tag to delete unknown tags by age")
+	}
+
+	result := plan.New(config.Get().Provider)
+	result.CreatedAt = planTime(source)
+
+	cache := newTagsCache()
+
+	// get stalled docker tags
+	if err := getStaleDockerTags(ctx, registry, cache, source, repositories, result); err != nil {
+		return nil, errors.Wrap(err, "can not get staled docker tags")
+	}
+
+	// delete tags of repositories which projects were deleted
+	if config.Get().Projects.Missing.Enabled {
+		if err := addMissingProjectTags(ctx, registry, cache, source, repositories, result); err != nil {
+			return nil, errors.Wrap(err, "can not get tags of missing projects")
+		}
+	}
+
+	// get staled snapshot tags
+	if config.Get().Snapshot.Enabled {
+		getStaledSnashotsTags(ctx, registry, cache, repositories, result)
+	}
+
+	// get stale uploads
+	if uploadsProvider, ok := registry.(types.UploadsProvider); ok {
+		result.Uploads, err = uploadsProvider.StaleUploads(ctx, repositories)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not get stale uploads")
+		}
+	}
+
+	// get repositories without tags and uploads
+	if repositoriesProvider, ok := registry.(types.RepositoriesProvider); ok {
+		result.Repositories, err = repositoriesProvider.EmptyRepositories(ctx, repositories)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not get empty repositories")
+		}
+	}
+
+	// signatures, attestations and sboms are deleted with their subject
+	addReferrerTags(ctx, registry, cache, result)
+
+	// do not delete images that are used in kubernetes clusters and their referrer tags
+	if config.Get().Kubernetes.Enabled {
+		if len(config.Get().Inventory) > 0 {
+			log.Warn("images of kubernetes clusters are not in inventory, images in use are not protected")
+		} else if err := protectInUseImages(ctx, registry, result); err != nil {
+			return nil, err
+		}
+	}
+
+	// do not delete manifests that are used by kept tags
+	protectSharedDigests(ctx, registry, cache, result)
+
+	if referrersProvider, ok := registry.(types.ReferrersProvider); ok {
+		addReferrers(ctx, referrersProvider, result)
+	}
+
+	if detailsProvider, ok := registry.(types.DetailsProvider); ok && config.Get().Tag.Details {
+		addTagDetails(ctx, detailsProvider, result)
+	}
+
+	return result, nil
+}
+
+// load plan and skip tags that was changed after plan was created.
+func loadPlan(ctx context.Context, registry types.Provider) (*plan.Plan, error) {
+	result, err := plan.Load(config.Get().Plan)
+	if err != nil {
+		return nil, errors.Wrap(err, config.Get().Plan)
+	}
+
+	if result.Provider != config.Get().Provider {
+		return nil, errors.Errorf("plan was created for %s provider, current provider %s", result.Provider, config.Get().Provider) //nolint:lll
+	}
+
+	log.Infof("plan created at %s, tags to delete %d", result.CreatedAt.String(), len(result.Delete))
+
 	tagsToDelete := make([]types.DeleteTagInput, 0)
+
+	for _, tag := range result.Delete {
+		digest, err := registry.Digest(ctx, tag.Repository, tag.Tag)
+		if err != nil {
+			metrics.TagsErrors.Inc()
+			log.WithError(err).Errorf("%s:%s can not get digest, tag will not be deleted", tag.Repository, tag.Tag)
+
+			continue
+		}
+
+		if digest != tag.Digest {
+			metrics.TagsWarnings.Inc()
+			log.Warnf("skip image=%s:%s digest was changed from %s to %s", tag.Repository, tag.Tag, tag.Digest, digest)
+
+			continue
+		}
+
+		tagsToDelete = append(tagsToDelete, tag)
+	}
+
+	result.Delete = tagsToDelete
+
+	// images can be deployed after plan
+	if config.Get().Kubernetes.Enabled {
+		if err := protectInUseImages(ctx, registry, result); err != nil {
+			return nil, err
+		}
+	}
+
+	// tags with same digest can be created after plan
+	protectSharedDigests(ctx, registry, newTagsCache(), result)
+
+	return result, nil
+}
+
+// get staled docker tags to delete from docker registry.
+func getStaleDockerTags(ctx context.Context, registry types.Provider, cache *tagsCache, source gitlab.Source, repositories []string, result *plan.Plan) error { //nolint:funlen,gocognit,lll,cyclop
+	resolver := newProjectResolver(source)
 	gitlabProjects := make(map[string][]string)
+	gitlabProjectList := make(map[string]*types.GitlabProject)
 
 	// Convert docker path to gitlab project path
 	for _, repo := range repositories {
 		log.Debug("docker repositories", repo)
 
-		gitlabProjectPath, err := api.GetGitlabProjectPath(repo)
+		paths, err := resolver.candidates(ctx, registry, repo)
 		if err != nil {
 			log.WithError(err).Warn()
 			metrics.TagsWarnings.Inc()
@@ -198,158 +432,952 @@ func getStaleDockerTags(ctx context.Context, registry types.Provider, repositori
 		}
 
 		// ignore some projects
-		if ignoreRepositoryRegexp.MatchString(gitlabProjectPath) {
+		if isIgnoredProject(paths) {
 			continue
 		}
 
-		// create unique gitlab projects
-		if gitlabProjects[gitlabProjectPath] == nil {
-			gitlabProjects[gitlabProjectPath] = []string{repo}
-		} else {
-			gitlabProjects[gitlabProjectPath] = append(gitlabProjects[gitlabProjectPath], repo)
+		gitlabProject, err := resolver.resolve(ctx, paths)
+		if err != nil {
+			var unmapped *unmappedError
+
+			if errors.As(err, &unmapped) {
+				addUnmappedRepository(repo, unmapped, result)
+
+				continue
+			}
+
+			if err := skipProject(repo, err); err != nil {
+				return err
+			}
+
+			continue
 		}
+
+		// repositories of old and new paths of moved project share branches and release tags
+		projectPath := gitlabProject.currentPath()
+
+		if gitlabProject.moved() {
+			addMovedRepository(repo, gitlabProject, result)
+		}
+
+		gitlabProjects[projectPath] = append(gitlabProjects[projectPath], repo)
+		gitlabProjectList[projectPath] = &gitlabProject.GitlabProject
 	}
 
 	// For all gitlab project list branch and detect stale docker tag
+projects:
 	for gitlabRepo, dockerRepos := range gitlabProjects {
-		gitlabProjectID, err := gitlab.GetProjectID(ctx, gitlabRepo)
-		if err != nil {
-			log.WithError(err).Error(gitlabRepo)
+		gitlabProject := gitlabProjectList[gitlabRepo]
 
-			continue
-		}
+		log.Debugf("gitlab repositories %s %d %v", gitlabRepo, gitlabProject.ID, dockerRepos)
 
-		log.Debugf("gitlab repositories %s %d %v", gitlabRepo, gitlabProjectID, dockerRepos)
+		// tags of archived project are deleted without branches, merge requests and deployments
+		archived := gitlabProject.Archived && config.Get().Projects.Archived.Enabled
+		projectData := &types.ProjectContext{}
 
-		projectBranches, err := gitlab.GetProjectBranches(ctx, gitlabProjectID)
-		if err != nil {
-			return tagsToDelete, errors.Wrap(err, "can not get branches")
-		}
+		if !archived {
+			data, err := getProjectData(ctx, source, gitlabProject.ID)
+			if err != nil {
+				if err := skipProject(gitlabRepo, err); err != nil {
+					return err
+				}
+
+				continue
+			}
 
-		log.Debugf("projectBranches %v", projectBranches)
+			projectData = data
+		}
 
-		projectAllDockerTags := make(map[string]types.TagType)
+		// docker repositories of project can have different policies
+		policies := make(map[string]*config.Policy)
+		repoPolicy := make(map[string]string)
+		projectAllDockerTags := make(map[string]map[string]types.TagType)
+		repositoryTags := make(map[string][]string)
 
 		// Get docker tags
 		for _, dockerRepo := range dockerRepos {
-			dockerTags, _ := registry.Tags(ctx, dockerRepo)
-			for _, dockerTag := range dockerTags {
-				projectAllDockerTags[dockerTag] = types.Unknown
-			}
-		}
+			policy := config.Get().GetPolicy(gitlabRepo, dockerRepo)
 
-		tagsNotToDelete := api.GetNotDeletableTags(&api.GetNotDeletableTagsInput{
-			Tags:             projectAllDockerTags,
-			DateRegexp:       releaseTagRegexp,
-			NotDeleteDays:    *releaseNotDeleteDays,
-			MinNotDeleteTags: *minNotDeleteReleaseTags,
-		})
+			log.Debugf("%s uses policy %s", dockerRepo, policy.Name)
 
-		// Calculate tags to delete
-		for projectAllDockerTag := range projectAllDockerTags {
-			var tagType types.TagType
+			policies[policy.Name] = policy
+			repoPolicy[dockerRepo] = policy.Name
 
-			// remove arch from docker tag name
-			tagWithoutArch := api.GetTagWithoutArch(projectAllDockerTag)
+			if projectAllDockerTags[policy.Name] == nil {
+				projectAllDockerTags[policy.Name] = make(map[string]types.TagType)
+			}
 
-			if branchStale, ok := projectBranches[tagWithoutArch]; ok {
-				if branchStale.Staled {
-					tagType = types.BranchStale
-				} else {
-					tagType = types.BranchNotStaled
-
-					log.Debugf("%s branch %s (%s) has last commit less than %d days",
-						gitlabRepo,
-						branchStale.OriginalBranchName,
-						tagWithoutArch,
-						branchStale.StaledDays,
-					)
+			dockerTags, err := cache.list(ctx, registry, dockerRepo)
+			if err != nil {
+				if err := skipProject(gitlabRepo, errors.Wrapf(err, "can not list tags of %s", dockerRepo)); err != nil {
+					return err
 				}
-			} else {
-				tagType = types.BranchNotFound
+
+				continue projects
 			}
 
-			if releaseTagRegexp.MatchString(projectAllDockerTag) {
-				if utils.StringInSlice(projectAllDockerTag, tagsNotToDelete) {
-					tagType = types.ReleaseTagCanNotDelete
-				} else {
-					tagType = types.ReleaseTag
-				}
+			repositoryTags[dockerRepo] = dockerTags
+
+			for _, dockerTag := range dockerTags {
+				projectAllDockerTags[policy.Name][dockerTag] = types.Unknown
 			}
+		}
+
+		projectContexts := make(map[string]*types.ProjectContext)
+
+		for policyName, policyTags := range projectAllDockerTags {
+			policy := policies[policyName]
+
+			notDeleteDays := policy.ReleaseDaysNotDelete
 
-			if systemTagRegexp.MatchString(tagWithoutArch) {
-				tagType = types.SystemTag
+			// archived project keeps only latest release tags
+			if archived {
+				notDeleteDays = 0
 			}
 
-			if len(tagType) == 0 {
-				tagType = types.Unknown
+			tagsNotToDelete := api.GetNotDeletableTags(&api.GetNotDeletableTagsInput{
+				Tags:             policyTags,
+				DateRegexp:       policy.ReleaseTag,
+				NotDeleteDays:    notDeleteDays,
+				MinNotDeleteTags: policy.ReleaseMinTags,
+				Now:              result.CreatedAt,
+			})
+
+			projectContexts[policyName] = &types.ProjectContext{
+				Path:                   gitlabRepo,
+				ID:                     gitlabProject.ID,
+				Now:                    result.CreatedAt,
+				Branches:               projectData.Branches,
+				OpenMergeRequests:      projectData.OpenMergeRequests,
+				Deployments:            projectData.Deployments,
+				BranchStaleDays:        policy.BranchStaleDays,
+				ReleaseTagsNotToDelete: make(map[string]bool),
 			}
 
-			projectAllDockerTags[projectAllDockerTag] = tagType
+			for _, tag := range tagsNotToDelete {
+				projectContexts[policyName].ReleaseTagsNotToDelete[tag] = true
+			}
 		}
 
 		// List all tags
 		for _, dockerRepo := range dockerRepos {
-			dockerTags, _ := registry.Tags(ctx, dockerRepo)
-			for _, dockerTag := range dockerTags {
-				tagType := projectAllDockerTags[dockerTag]
-
-				switch tagType { //nolint:exhaustive
-				case types.ReleaseTag, types.BranchNotFound, types.BranchStale:
-					tagsToDelete = append(tagsToDelete, types.DeleteTagInput{
-						Repository: dockerRepo,
-						Tag:        dockerTag,
-						TagType:    tagType,
-					})
-				case types.Unknown:
-					log.Warnf("%s:%s,%s", dockerRepo, dockerTag, tagType)
-				default:
-					log.Infof("%s:%s,%s", dockerRepo, dockerTag, tagType)
-				}
+			chain := classifier.New(policies[repoPolicy[dockerRepo]])
+			if archived {
+				chain = classifier.NewArchived(policies[repoPolicy[dockerRepo]])
 			}
+
+			classifyTags(ctx, registry, chain, dockerRepo, repositoryTags[dockerRepo], projectContexts[repoPolicy[dockerRepo]], result) //nolint:lll
+		}
+	}
+
+	return nil
+}
+
+// classify tags of repository, unknown tags are deleted when they are expired.
+func classifyTags(ctx context.Context, registry types.Provider, chain *classifier.Chain, repository string, tags []string, project *types.ProjectContext, result *plan.Plan) { //nolint:lll
+	unknownTags := make([]types.KeepTagInput, 0)
+
+	for _, tag := range tags {
+		classified := chain.Classify(&types.ClassifierInput{
+			Repository: repository,
+			Tag:        tag,
+			Project:    project,
+		})
+
+		if classified.Delete {
+			result.Delete = append(result.Delete, types.DeleteTagInput{
+				Repository: repository,
+				Tag:        tag,
+				TagType:    classified.TagType,
+				Reason:     classified.Reason,
+			})
+
+			continue
+		}
+
+		keepTag := types.KeepTagInput{
+			Repository: repository,
+			Tag:        tag,
+			TagType:    classified.TagType,
+			Reason:     classified.Reason,
+		}
+
+		if classified.TagType == types.Unknown {
+			unknownTags = append(unknownTags, keepTag)
+
+			continue
 		}
+
+		log.Infof("%s:%s,%s %s", repository, tag, classified.TagType, classified.Reason)
+
+		result.Keep = append(result.Keep, keepTag)
 	}
 
-	return tagsToDelete, nil
+	addUnknownTags(ctx, registry, repository, unknownTags, result)
 }
 
-// get staled snapshots tags to delete from docker registry.
-func getStaledSnashotsTags(ctx context.Context, registry types.Provider, repositories []string) []types.DeleteTagInput {
-	tagsToDelete := make([]types.DeleteTagInput, 0)
+// branches, open merge requests and deployments of gitlab project.
+func getProjectData(ctx context.Context, source gitlab.Source, projectID int) (*types.ProjectContext, error) {
+	projectBranches, err := source.GetProjectBranches(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not get branches")
+	}
 
-	for _, dockerRepo := range repositories {
-		if snapshotRepositoryRegexp.MatchString(dockerRepo) {
-			snapshotsDockerTags := make(map[string]types.TagType)
-			dockerTags, _ := registry.Tags(ctx, dockerRepo)
+	log.Debugf("projectBranches %v", projectBranches)
 
-			// get all repository tags
-			for _, dockerTag := range dockerTags {
-				snapshotsDockerTags[dockerTag] = types.SnapshotStaled
-			}
+	projectMergeRequests := make(map[string]*types.MergeRequest)
 
-			tagsNotToDelete := api.GetNotDeletableTags(&api.GetNotDeletableTagsInput{
-				Tags:             snapshotsDockerTags,
-				DateRegexp:       snapshotTagRegexp,
-				NotDeleteDays:    *snapshotNotDeleteDays,
-				MinNotDeleteTags: *minNotDeleteSnapshotTags,
-			})
+	if config.Get().Branch.KeepOpenMergeRequests {
+		projectMergeRequests, err = source.GetProjectOpenMergeRequests(ctx, projectID)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not get merge requests")
+		}
 
-			// Calculate tags to delete
-			for snapshotsDockerTag, tagType := range snapshotsDockerTags {
-				if utils.StringInSlice(snapshotsDockerTag, tagsNotToDelete) {
-					tagType = types.SnapshotTagCanNotDelete
-				}
+		log.Debugf("projectMergeRequests %v", projectMergeRequests)
+	}
 
-				if tagType == types.SnapshotStaled {
-					tagsToDelete = append(tagsToDelete, types.DeleteTagInput{
-						Repository: dockerRepo,
-						Tag:        snapshotsDockerTag,
-						TagType:    tagType,
-					})
-				}
-			}
+	projectDeployments := make(map[string][]*types.Deployment)
+
+	if config.Get().Environments.KeepDeployed {
+		projectDeployments, err = source.GetProjectDeployments(ctx, projectID)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not get deployments")
 		}
+
+		log.Debugf("projectDeployments %v", projectDeployments)
+	}
+
+	return &types.ProjectContext{
+		ID:                projectID,
+		Branches:          projectBranches,
+		OpenMergeRequests: projectMergeRequests,
+		Deployments:       projectDeployments,
+	}, nil
+}
+
+// project or repository with failed request is skipped or run is aborted with retry.onError.
+func skipProject(project string, err error) error {
+	if config.Get().Retry.OnError == config.OnErrorAbort {
+		return errors.Wrap(err, project)
 	}
 
-	return tagsToDelete
+	metrics.TagsErrors.Inc()
+	log.WithError(err).Errorf("%s is skipped, its tags will not be deleted", project)
+
+	return nil
+}
+
+// repository without gitlab project is reported in plan, its tags are not deleted.
+func addUnmappedRepository(repository string, err *unmappedError, result *plan.Plan) {
+	metrics.RepositoriesUnmapped.Inc()
+	log.WithError(err).Warnf("%s is not in any gitlab project, its tags will not be deleted", repository)
+
+	result.Unmapped = append(result.Unmapped, types.UnmappedRepository{
+		Repository: repository,
+		Reason:     err.Error(),
+		Projects:   err.paths,
+	})
+}
+
+// repository of renamed or transferred project is reported in plan, its tags are classified with current project.
+func addMovedRepository(repository string, gitlabProject *project, result *plan.Plan) {
+	metrics.RepositoriesMoved.Inc()
+	log.Infof("%s is in project %s that was moved to %s", repository, gitlabProject.Path, gitlabProject.currentPath())
+
+	result.Moved = append(result.Moved, types.MovedRepository{
+		Repository: repository,
+		Project:    gitlabProject.Path,
+		MovedTo:    gitlabProject.currentPath(),
+	})
+}
+
+// delete unknown tags which images are older than unknown.daysNotDelete, other unknown tags are kept.
+func addUnknownTags(ctx context.Context, registry types.Provider, repository string, tags []types.KeepTagInput, result *plan.Plan) { //nolint:lll
+	unknownConfig := config.Get().Unknown
+	details := make(map[string]*types.TagDetails)
+	created := make(map[string]time.Time)
+
+	if detailsProvider, ok := registry.(types.DetailsProvider); ok && unknownConfig.Enabled {
+		for _, tag := range tags {
+			tagDetails, err := detailsProvider.TagDetails(ctx, repository, tag.Tag)
+			if err != nil {
+				metrics.TagsWarnings.Inc()
+				log.WithError(err).Warnf("%s:%s can not get tag details, tag will not be deleted", repository, tag.Tag)
+
+				continue
+			}
+
+			details[tag.Tag] = tagDetails
+			created[tag.Tag] = tagDetails.Created
+		}
+	}
+
+	expired := api.GetExpiredTags(&api.GetExpiredTagsInput{
+		Created:          created,
+		NotDeleteDays:    unknownConfig.DaysNotDelete,
+		MinNotDeleteTags: unknownConfig.MinTags,
+		Now:              result.CreatedAt,
+	})
+
+	for _, tag := range tags {
+		if !utils.StringInSlice(tag.Tag, expired) {
+			log.Warnf("%s:%s,%s", repository, tag.Tag, tag.TagType)
+
+			result.Keep = append(result.Keep, tag)
+
+			continue
+		}
+
+		result.Delete = append(result.Delete, types.DeleteTagInput{
+			Repository: repository,
+			Tag:        tag.Tag,
+			TagType:    types.UnknownExpired,
+			Reason: fmt.Sprintf("image created at %s is older than %g days",
+				created[tag.Tag].UTC().Format(time.RFC3339),
+				unknownConfig.DaysNotDelete,
+			),
+			Digest: details[tag.Tag].Digest,
+		})
+	}
+}
+
+// add digests to tags and skip tags which manifest digest is shared with kept tags
+// when provider deletes manifest of tag.
+func protectSharedDigests(ctx context.Context, registry types.Provider, cache *tagsCache, result *plan.Plan) { //nolint:funlen
+	repositories := make(map[string][]types.DeleteTagInput)
+
+	for _, tag := range result.Delete {
+		repositories[tag.Repository] = append(repositories[tag.Repository], tag)
+	}
+
+	tagsToDelete := make([]types.DeleteTagInput, 0)
+	// other tags of manifest are deleted with tag only by providers that delete manifest
+	sharedDeleted := deletesManifest(registry)
+
+	for repository, repositoryTagsToDelete := range repositories {
+		tagDigests, err := getTagDigests(ctx, registry, cache, repository, repositoryTagsToDelete, sharedDeleted)
+		if err != nil {
+			metrics.TagsErrors.Inc()
+			log.WithError(err).Errorf("%s can not verify digests, tags will not be deleted", repository)
+
+			continue
+		}
+
+		verifiedTags := make([]types.DeleteTagInput, 0)
+
+		for _, tag := range repositoryTagsToDelete {
+			if digest, ok := tagDigests[tag.Tag]; ok {
+				tag.Digest = digest
+				verifiedTags = append(verifiedTags, tag)
+			}
+		}
+
+		filtered := verifiedTags
+
+		if sharedDeleted {
+			filtered = protectSharedManifests(ctx, registry, repository, verifiedTags, tagDigests, result)
+		}
+
+		for i, tag := range result.Keep {
+			if tag.Repository == repository && len(tag.Digest) == 0 {
+				result.Keep[i].Digest = tagDigests[tag.Tag]
+			}
+		}
+
+		tagsToDelete = append(tagsToDelete, filtered...)
+	}
+
+	result.Delete = tagsToDelete
+}
+
+// skip tags which manifests are used by kept tags directly or as part of index.
+func protectSharedManifests(ctx context.Context, registry types.Provider, repository string, tags []types.DeleteTagInput, tagDigests map[string]string, result *plan.Plan) []types.DeleteTagInput { //nolint:lll,funlen
+	filtered, shared := api.FilterSharedDigests(tags, tagDigests)
+
+	for _, item := range shared {
+		metrics.TagsSharedDigest.Inc()
+		log.Warnf("skip image=%s:%s reason=%s digest %s is used by kept tags %v",
+			item.Tag.Repository,
+			item.Tag.Tag,
+			item.Tag.TagType.String(),
+			item.Tag.Digest,
+			item.KeptTags,
+		)
+
+		result.Keep = append(result.Keep, types.KeepTagInput{
+			Repository: item.Tag.Repository,
+			Tag:        item.Tag.Tag,
+			TagType:    types.SharedDigest,
+			Reason:     fmt.Sprintf("%s, digest is used by kept tags %v", item.Tag.TagType.String(), item.KeptTags),
+			Digest:     item.Tag.Digest,
+		})
+	}
+
+	if indexProvider, ok := registry.(types.IndexProvider); ok {
+		children, err := getIndexChildren(ctx, indexProvider, repository, tagDigests)
+		if err != nil {
+			metrics.TagsErrors.Inc()
+			log.WithError(err).Errorf("%s can not get manifests of index, tags will not be deleted", repository)
+
+			return []types.DeleteTagInput{}
+		}
+
+		filtered, shared = api.FilterIndexChildren(filtered, tagDigests, children)
+
+		for _, item := range shared {
+			metrics.TagsSharedDigest.Inc()
+			log.Warnf("skip image=%s:%s reason=%s manifest %s is part of index of kept tags %v",
+				item.Tag.Repository,
+				item.Tag.Tag,
+				item.Tag.TagType.String(),
+				item.Tag.Digest,
+				item.KeptTags,
+			)
+
+			result.Keep = append(result.Keep, types.KeepTagInput{
+				Repository: item.Tag.Repository,
+				Tag:        item.Tag.Tag,
+				TagType:    types.SharedDigest,
+				Reason:     fmt.Sprintf("%s, manifest is part of index of kept tags %v", item.Tag.TagType.String(), item.KeptTags),
+				Digest:     item.Tag.Digest,
+			})
+		}
+	}
+
+	return filtered
+}
+
+// referrer tags inherit decision of subject manifest, tags which subject is not found are deleted
+// only when provider can read platform manifests of kept indexes.
+func addReferrerTags(ctx context.Context, registry types.Provider, cache *tagsCache, result *plan.Plan) { //nolint:funlen,cyclop
+	repositories := make(map[string][]string)
+
+	for _, tag := range result.Keep {
+		if tag.TagType == types.Referrer {
+			repositories[tag.Repository] = append(repositories[tag.Repository], tag.Tag)
+		}
+	}
+
+	indexProvider, canVerify := registry.(types.IndexProvider)
+	deletedTags := make(map[string]bool)
+
+	for repository, referrerTags := range repositories {
+		repositoryTagsToDelete := make([]types.DeleteTagInput, 0)
+
+		for _, tag := range result.Delete {
+			if tag.Repository == repository {
+				repositoryTagsToDelete = append(repositoryTagsToDelete, tag)
+			}
+		}
+
+		tagDigests, err := getTagDigests(ctx, registry, cache, repository, repositoryTagsToDelete, true)
+		if err != nil {
+			metrics.TagsErrors.Inc()
+			log.WithError(err).Errorf("%s can not verify digests, referrer tags will not be deleted", repository)
+
+			continue
+		}
+
+		deletedDigests := make(map[string]bool)
+
+		for _, tag := range repositoryTagsToDelete {
+			// digest of tag is set by digest verification later
+			if digest, ok := tagDigests[tag.Tag]; ok {
+				deletedDigests[digest] = true
+			}
+
+			delete(tagDigests, tag.Tag)
+
+			deletedDigests[tag.Digest] = true
+
+			for _, child := range tag.Children {
+				deletedDigests[child] = true
+			}
+		}
+
+		keptDigests := make(map[string]bool)
+
+		for _, digest := range tagDigests {
+			keptDigests[digest] = true
+		}
+
+		if canVerify {
+			children, err := getIndexChildren(ctx, indexProvider, repository, tagDigests)
+			if err != nil {
+				metrics.TagsErrors.Inc()
+				log.WithError(err).Errorf("%s can not get manifests of index, referrer tags will not be deleted", repository)
+
+				continue
+			}
+
+			for _, indexChildren := range children {
+				for _, child := range indexChildren {
+					keptDigests[child] = true
+				}
+			}
+		}
+
+		decisions := api.GetReferrerTagsToDelete(&api.GetReferrerTagsInput{
+			Tags:           referrerTags,
+			DeletedDigests: deletedDigests,
+			KeptDigests:    keptDigests,
+			DeleteOrphans:  canVerify,
+		})
+
+		tagsToDelete := make([]types.DeleteTagInput, 0, len(decisions))
+
+		for _, tag := range referrerTags {
+			tagType, ok := decisions[tag]
+			if !ok {
+				continue
+			}
+
+			subject, _ := api.GetReferrerSubject(tag)
+
+			reason := fmt.Sprintf("subject manifest %s is deleted", subject)
+			if tagType == types.ReferrerSubjectNotFound {
+				reason = fmt.Sprintf("subject manifest %s not found in repository", subject)
+			}
+
+			tagsToDelete = append(tagsToDelete, types.DeleteTagInput{
+				Repository: repository,
+				Tag:        tag,
+				TagType:    tagType,
+				Reason:     reason,
+			})
+		}
+
+		filtered, shared := api.FilterSharedDigests(tagsToDelete, tagDigests)
+
+		for _, item := range shared {
+			metrics.TagsSharedDigest.Inc()
+			log.Warnf("skip image=%s:%s reason=%s digest %s is used by kept tags %v",
+				item.Tag.Repository,
+				item.Tag.Tag,
+				item.Tag.TagType.String(),
+				item.Tag.Digest,
+				item.KeptTags,
+			)
+		}
+
+		for _, tag := range filtered {
+			log.Infof("%s:%s,%s %s", tag.Repository, tag.Tag, tag.TagType, tag.Reason)
+
+			deletedTags[repository+":"+tag.Tag] = true
+			result.Delete = append(result.Delete, tag)
+		}
+	}
+
+	keepTags := make([]types.KeepTagInput, 0, len(result.Keep))
+
+	for _, tag := range result.Keep {
+		if !deletedTags[tag.Repository+":"+tag.Tag] {
+			keepTags = append(keepTags, tag)
+		}
+	}
+
+	result.Keep = keepTags
+}
+
+// add manifests from OCI referrers API of deleted manifests and their platform manifests.
+func addReferrers(ctx context.Context, registry types.ReferrersProvider, result *plan.Plan) {
+	for i, tag := range result.Delete {
+		digests := append([]string{tag.Digest}, tag.Children...)
+		referrers := make([]string, 0)
+
+		for len(digests) > 0 {
+			digest := digests[0]
+			digests = digests[1:]
+
+			if len(digest) == 0 {
+				continue
+			}
+
+			items, err := registry.Referrers(ctx, tag.Repository, digest)
+			if err != nil {
+				metrics.TagsWarnings.Inc()
+				log.WithError(err).Warnf("%s:%s can not get referrers of %s", tag.Repository, tag.Tag, digest)
+
+				continue
+			}
+
+			// referrers can have own referrers, for example signature of sbom
+			referrers = append(referrers, items...)
+			digests = append(digests, items...)
+		}
+
+		if len(referrers) > 0 {
+			result.Delete[i].Referrers = referrers
+		}
+	}
+}
+
+// skip tags which images are used in kubernetes clusters, plan is aborted when cluster can not be read
+// unless kubernetes.ignoreErrors is set.
+func protectInUseImages(ctx context.Context, registry types.Provider, result *plan.Plan) error {
+	images, clusterErrors := kubernetes.GetImages(ctx)
+
+	for _, err := range clusterErrors {
+		metrics.TagsErrors.Inc()
+		log.WithError(err).Error("can not get images from kubernetes cluster")
+	}
+
+	// images of cluster that can not be read are not protected
+	if len(clusterErrors) > 0 && !config.Get().Kubernetes.IgnoreErrors {
+		return errors.Wrapf(clusterErrors[0], "can not get images from %d kubernetes clusters", len(clusterErrors))
+	}
+
+	protectImages(ctx, registry, images, result)
+
+	return nil
+}
+
+// skip tags which images are in list and referrer tags which subject manifest is in list or kept.
+func protectImages(ctx context.Context, registry types.Provider, images *kubernetes.Images, result *plan.Plan) { //nolint:funlen,cyclop,lll
+	tagsToDelete := make([]types.DeleteTagInput, 0)
+
+	// sources of manifests in use by repository@digest
+	inUseDigests := make(map[string][]string)
+
+	for _, tag := range result.Delete {
+		sources := images.Tag(tag.Repository, tag.Tag)
+
+		// image can be referenced by manifest digest
+		if len(sources) == 0 && images.HasDigests(tag.Repository) {
+			digest := tag.Digest
+
+			if len(digest) == 0 {
+				var err error
+
+				digest, err = registry.Digest(ctx, tag.Repository, tag.Tag)
+				if err != nil {
+					metrics.TagsErrors.Inc()
+					log.WithError(err).Errorf("%s:%s can not get digest, tag will not be deleted", tag.Repository, tag.Tag)
+
+					continue
+				}
+			}
+
+			tag.Digest = digest
+			sources = images.Digest(tag.Repository, digest)
+		}
+
+		if len(sources) == 0 {
+			tagsToDelete = append(tagsToDelete, tag)
+
+			continue
+		}
+
+		// referrer tags of this manifest are kept too
+		if len(tag.Digest) == 0 {
+			if digest, err := registry.Digest(ctx, tag.Repository, tag.Tag); err == nil {
+				tag.Digest = digest
+			}
+		}
+
+		if len(tag.Digest) > 0 {
+			inUseDigests[tag.Repository+"@"+tag.Digest] = sources
+		}
+
+		keepInUse(result, tag, fmt.Sprintf("image is used by %s", strings.Join(sources, ", ")))
+	}
+
+	result.Delete = make([]types.DeleteTagInput, 0, len(tagsToDelete))
+
+	// signatures, attestations and sboms of images in use
+	for _, tag := range tagsToDelete {
+		subject, ok := api.GetReferrerSubject(tag.Tag)
+		if !ok {
+			result.Delete = append(result.Delete, tag)
+
+			continue
+		}
+
+		sources := images.Digest(tag.Repository, subject)
+		if len(sources) == 0 {
+			sources = inUseDigests[tag.Repository+"@"+subject]
+		}
+
+		if len(sources) == 0 {
+			result.Delete = append(result.Delete, tag)
+
+			continue
+		}
+
+		keepInUse(result, tag, fmt.Sprintf("subject manifest %s is used by %s", subject, strings.Join(sources, ", ")))
+	}
+}
+
+// move tag to kept tags as image in use.
+func keepInUse(result *plan.Plan, tag types.DeleteTagInput, reason string) {
+	metrics.TagsInUse.Inc()
+	log.Warnf("skip image=%s:%s reason=%s %s",
+		tag.Repository,
+		tag.Tag,
+		tag.TagType.String(),
+		reason,
+	)
+
+	result.Keep = append(result.Keep, types.KeepTagInput{
+		Repository: tag.Repository,
+		Tag:        tag.Tag,
+		TagType:    types.InUse,
+		Reason:     fmt.Sprintf("%s, %s", tag.TagType.String(), reason),
+		Digest:     tag.Digest,
+	})
+}
+
+// get platform manifests of all indexes in repository, key is index digest.
+func getIndexChildren(ctx context.Context, registry types.IndexProvider, repository string, tagDigests map[string]string) (map[string][]string, error) { //nolint:lll
+	digests := make([]string, 0, len(tagDigests))
+	unique := make(map[string]bool)
+
+	for _, digest := range tagDigests {
+		if !unique[digest] {
+			unique[digest] = true
+			digests = append(digests, digest)
+		}
+	}
+
+	results := make([][]string, len(digests))
+	errs := make([]error, len(digests))
+
+	utils.Parallel(config.Get().Registry.Workers, len(digests), func(i int) {
+		results[i], errs[i] = registry.Children(ctx, repository, digests[i])
+	})
+
+	children := make(map[string][]string)
+
+	for i, digest := range digests {
+		if errs[i] != nil {
+			return nil, errors.Wrap(errs[i], digest)
+		}
+
+		children[digest] = results[i]
+	}
+
+	return children, nil
+}
+
+// provider deletes manifest of tag with all tags of this manifest.
+func deletesManifest(registry types.Provider) bool {
+	manifestProvider, ok := registry.(types.ManifestProvider)
+
+	return ok && manifestProvider.DeletesManifest()
+}
+
+// tags of repositories that were listed for one plan, plan steps use tags that were classified.
+type tagsCache struct {
+	mutex sync.Mutex
+	tags  map[string][]string
+}
+
+func newTagsCache() *tagsCache {
+	return &tagsCache{
+		tags: make(map[string][]string),
+	}
+}
+
+// list tags of repository once.
+func (c *tagsCache) list(ctx context.Context, registry types.Provider, repository string) ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if tags, ok := c.tags[repository]; ok {
+		return tags, nil
+	}
+
+	tags, err := registry.Tags(ctx, repository)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	c.tags[repository] = tags
+
+	return tags, nil
+}
+
+// get digests of all repository tags, tags to delete without digest will not be in result.
+// digests of all tags of repository, kept tags must have digest when shared manifests are protected.
+func getTagDigests(ctx context.Context, registry types.Provider, cache *tagsCache, repository string, tagsToDelete []types.DeleteTagInput, strict bool) (map[string]string, error) { //nolint:lll
+	deleteTags := make(map[string]bool)
+
+	for _, tag := range tagsToDelete {
+		deleteTags[tag.Tag] = true
+	}
+
+	tags, err := cache.list(ctx, registry, repository)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not list tags")
+	}
+
+	digests := make([]string, len(tags))
+	errs := make([]error, len(tags))
+
+	utils.Parallel(config.Get().Registry.Workers, len(tags), func(i int) {
+		digests[i], errs[i] = registry.Digest(ctx, repository, tags[i])
+	})
+
+	tagDigests := make(map[string]string)
+
+	for i, tag := range tags {
+		digest, err := digests[i], errs[i]
+		if err != nil {
+			// kept tag without digest can share manifest with any tag
+			if !deleteTags[tag] && strict {
+				return nil, errors.Wrapf(err, "can not get digest of kept tag %s", tag)
+			}
+
+			metrics.TagsErrors.Inc()
+			log.WithError(err).Errorf("%s:%s can not get digest, tag will not be deleted", repository, tag)
+
+			continue
+		}
+
+		tagDigests[tag] = digest
+	}
+
+	return tagDigests, nil
+}
+
+// add manifest details of tags to plan, tags without details are not changed.
+func addTagDetails(ctx context.Context, registry types.DetailsProvider, result *plan.Plan) {
+	getDetails := func(repository, tag string) *types.TagDetails {
+		details, err := registry.TagDetails(ctx, repository, tag)
+		if err != nil {
+			metrics.TagsWarnings.Inc()
+			log.WithError(err).Warnf("%s:%s can not get tag details", repository, tag)
+
+			return nil
+		}
+
+		return details
+	}
+
+	var deleteSize int64
+
+	tagsToDelete := make([]types.DeleteTagInput, 0, len(result.Delete))
+	changedTags := make([]types.KeepTagInput, 0)
+
+	for _, tag := range result.Delete {
+		details := getDetails(tag.Repository, tag.Tag)
+		if details == nil {
+			tagsToDelete = append(tagsToDelete, tag)
+
+			continue
+		}
+
+		// tag was pushed again after classification
+		if len(tag.Digest) > 0 && tag.Digest != details.Digest {
+			metrics.TagsWarnings.Inc()
+			log.Warnf("skip image=%s:%s digest was changed from %s to %s", tag.Repository, tag.Tag, tag.Digest, details.Digest)
+
+			changedTags = append(changedTags, types.KeepTagInput{
+				Repository: tag.Repository,
+				Tag:        tag.Tag,
+				TagType:    types.DigestChanged,
+				Reason:     fmt.Sprintf("digest was changed from %s after classification as %s", tag.Digest, tag.TagType),
+				Digest:     details.Digest,
+				MediaType:  details.MediaType,
+				Created:    createdTime(details),
+				Size:       details.Size,
+			})
+
+			continue
+		}
+
+		tag.Digest = details.Digest
+		tag.MediaType = details.MediaType
+		tag.Created = createdTime(details)
+		tag.Size = details.Size
+
+		tagsToDelete = append(tagsToDelete, tag)
+		deleteSize += details.Size
+	}
+
+	result.Delete = tagsToDelete
+
+	for i, tag := range result.Keep {
+		details := getDetails(tag.Repository, tag.Tag)
+		if details == nil {
+			continue
+		}
+
+		if len(tag.Digest) == 0 {
+			result.Keep[i].Digest = details.Digest
+		}
+
+		result.Keep[i].MediaType = details.MediaType
+		result.Keep[i].Created = createdTime(details)
+		result.Keep[i].Size = details.Size
+	}
+
+	result.Keep = append(result.Keep, changedTags...)
+
+	// layers can be shared with kept images, real reclaimed size is smaller
+	log.Infof("compressed size of images to delete %d bytes", deleteSize)
+}
+
+// creation time is not set for images without config.
+func createdTime(details *types.TagDetails) *time.Time {
+	if details.Created.IsZero() {
+		return nil
+	}
+
+	created := details.Created.UTC()
+
+	return &created
+}
+
+// get staled snapshots tags to delete from docker registry.
+func getStaledSnashotsTags(ctx context.Context, registry types.Provider, cache *tagsCache, repositories []string, result *plan.Plan) { //nolint:lll
+	for _, dockerRepo := range repositories {
+		if snapshotRepositoryRegexp.MatchString(dockerRepo) {
+			dockerTags, err := cache.list(ctx, registry, dockerRepo)
+			if err != nil {
+				metrics.TagsErrors.Inc()
+				log.WithError(err).Errorf("%s can not list tags, snapshots will not be deleted", dockerRepo)
+
+				continue
+			}
+
+			snapshotsDockerTags := make(map[string]types.TagType)
+
+			// get all repository tags
+			for _, dockerTag := range dockerTags {
+				snapshotsDockerTags[dockerTag] = types.SnapshotStaled
+			}
+
+			tagsNotToDelete := api.GetNotDeletableTags(&api.GetNotDeletableTagsInput{
+				Tags:             snapshotsDockerTags,
+				DateRegexp:       snapshotTagRegexp,
+				NotDeleteDays:    config.Get().Snapshot.DaysNotDelete,
+				MinNotDeleteTags: config.Get().Snapshot.MinTags,
+				Now:              result.CreatedAt,
+			})
+
+			// Calculate tags to delete
+			for snapshotsDockerTag, tagType := range snapshotsDockerTags {
+				if utils.StringInSlice(snapshotsDockerTag, tagsNotToDelete) {
+					tagType = types.SnapshotTagCanNotDelete
+				}
+
+				if tagType == types.SnapshotStaled {
+					result.Delete = append(result.Delete, types.DeleteTagInput{
+						Repository: dockerRepo,
+						Tag:        snapshotsDockerTag,
+						TagType:    tagType,
+						Reason:     "snapshot is out of retention",
+					})
+				} else {
+					result.Keep = append(result.Keep, types.KeepTagInput{
+						Repository: dockerRepo,
+						Tag:        snapshotsDockerTag,
+						TagType:    tagType,
+						Reason:     "snapshot is in retention",
+					})
+				}
+			}
+		}
+	}
 }
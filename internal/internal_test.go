@@ -0,0 +1,399 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/api"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/classifier"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/inventory"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/kubernetes"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/plan"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/state"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+)
+
+const (
+	testDigest      = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+	testStaleDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+	testNewDigest   = "sha256:0000000000000000000000000000000000000000000000000000000000000003"
+)
+
+// saved and loaded inventory.
+func testInventory(t *testing.T, result *inventory.Inventory) *inventory.Inventory {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "inventory.json")
+
+	if err := result.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := inventory.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loaded
+}
+
+// provider of saved and loaded inventory.
+func testProvider(t *testing.T, result *inventory.Inventory) types.Provider { //nolint:ireturn
+	t.Helper()
+
+	return inventory.NewProvider(testInventory(t, result))
+}
+
+func TestProtectSharedDigests(t *testing.T) {
+	for _, manifests := range []bool{true, false} {
+		result := inventory.New("docker")
+		result.Manifests = manifests
+		result.Repositories = append(result.Repositories, &inventory.Repository{
+			Repository: "group/project",
+			Tags: []*inventory.Tag{
+				{Tag: "main", Digest: testDigest},
+				{Tag: "feature", Digest: testDigest},
+				{Tag: "stale", Digest: testStaleDigest},
+			},
+		})
+
+		registry := testProvider(t, result)
+
+		testPlan := plan.New("docker")
+		testPlan.Keep = append(testPlan.Keep, types.KeepTagInput{Repository: "group/project", Tag: "main"})
+		testPlan.Delete = append(testPlan.Delete,
+			types.DeleteTagInput{Repository: "group/project", Tag: "feature", TagType: types.BranchNotFound},
+			types.DeleteTagInput{Repository: "group/project", Tag: "stale", TagType: types.BranchNotFound},
+		)
+
+		protectSharedDigests(context.Background(), registry, newTagsCache(), testPlan)
+		testPlan.Sort()
+
+		// provider that deletes only tag keeps other tags of manifest
+		deleted := 2
+		if manifests {
+			deleted = 1
+		}
+
+		if len(testPlan.Delete) != deleted {
+			t.Fatalf("manifests=%t: tags to delete %+v is not correct", manifests, testPlan.Delete)
+		}
+
+		if testPlan.Delete[len(testPlan.Delete)-1].Digest != testStaleDigest {
+			t.Fatalf("manifests=%t: digest must be added to %+v", manifests, testPlan.Delete)
+		}
+
+		if manifests && testPlan.Keep[0].TagType != types.SharedDigest {
+			t.Fatalf("feature tag must be kept, %+v", testPlan.Keep)
+		}
+	}
+}
+
+func TestProtectInUseImagesError(t *testing.T) {
+	kubernetesConfig := config.Get().Kubernetes
+	defer func() { config.Get().Kubernetes = kubernetesConfig }()
+
+	config.Get().Kubernetes.Kubeconfig = filepath.Join(t.TempDir(), "kubeconfig")
+	config.Get().Kubernetes.Contexts = "production"
+
+	registry := testProvider(t, inventory.New("docker"))
+
+	for _, ignoreErrors := range []bool{false, true} {
+		config.Get().Kubernetes.IgnoreErrors = ignoreErrors
+
+		testPlan := plan.New("docker")
+		testPlan.Delete = append(testPlan.Delete, types.DeleteTagInput{Repository: "group/project", Tag: "feature"})
+
+		err := protectInUseImages(context.Background(), registry, testPlan)
+
+		// images of cluster that can not be read are not known
+		if !ignoreErrors && err == nil {
+			t.Fatal("unreadable cluster must abort plan")
+		}
+
+		if ignoreErrors && (err != nil || len(testPlan.Delete) != 1) {
+			t.Fatalf("unreadable cluster must be skipped, %v", err)
+		}
+	}
+}
+
+func TestProtectImagesReferrers(t *testing.T) {
+	t.Parallel()
+
+	images := kubernetes.NewImages("registry.example.com")
+
+	for _, image := range []string{
+		"registry.example.com/group/project:feature",
+		"registry.example.com/group/project@" + testStaleDigest,
+	} {
+		if err := images.Add(image, "default/pod/app"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	referrerTag := func(digest string) string {
+		return strings.Replace(digest, ":", "-", 1) + ".sig"
+	}
+
+	testPlan := plan.New("docker")
+	testPlan.Delete = append(testPlan.Delete,
+		types.DeleteTagInput{Repository: "group/project", Tag: "feature", Digest: testDigest},
+		types.DeleteTagInput{Repository: "group/project", Tag: referrerTag(testDigest), Digest: testNewDigest},
+		types.DeleteTagInput{Repository: "group/project", Tag: referrerTag(testStaleDigest), Digest: testNewDigest},
+		types.DeleteTagInput{Repository: "group/project", Tag: referrerTag(testNewDigest), Digest: testNewDigest},
+	)
+
+	protectImages(context.Background(), testProvider(t, inventory.New("docker")), images, testPlan)
+
+	// signatures of kept tag and of manifest used by digest are kept
+	if len(testPlan.Delete) != 1 || testPlan.Delete[0].Tag != referrerTag(testNewDigest) {
+		t.Fatalf("tags to delete %+v is not correct", testPlan.Delete)
+	}
+
+	for _, tag := range testPlan.Keep {
+		if tag.TagType != types.InUse {
+			t.Fatalf("tag %+v must be kept as in use", tag)
+		}
+	}
+}
+
+func TestClassifyTagsUnknown(t *testing.T) {
+	unknownConfig := config.Get().Unknown
+	defer func() { config.Get().Unknown = unknownConfig }()
+
+	config.Get().Unknown = config.Unknown{Enabled: true, DaysNotDelete: 30, MinTags: 1}
+
+	// plan from inventory uses time of inventory
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	tags := map[string]time.Time{
+		"main":         now,
+		"feature-gone": now,
+		"latest-test":  now.Add(-24 * time.Hour),
+		"3f2a9c1b":     now.Add(-60 * 24 * time.Hour),
+		"a1b2c3d4":     now.Add(-90 * 24 * time.Hour),
+	}
+
+	result := inventory.New("docker")
+	result.Details = true
+	result.CreatedAt = now
+	repository := &inventory.Repository{Repository: "group/project"}
+
+	for tag, created := range tags {
+		repository.Tags = append(repository.Tags, &inventory.Tag{
+			Tag:     tag,
+			Digest:  testDigest,
+			Details: &inventory.Details{Created: &created},
+		})
+	}
+
+	result.Repositories = append(result.Repositories, repository)
+
+	chain := classifier.New(&config.Policy{
+		ReleaseTag: regexp.MustCompile(`^release-(\d{8}).*$`),
+		SystemTag:  regexp.MustCompile(`^(main|master)$`),
+		BranchTag:  regexp.MustCompile(`^(feature|fix)-.+$`),
+	})
+
+	loaded := testInventory(t, result)
+
+	testPlan := plan.New("docker")
+	testPlan.CreatedAt = planTime(loaded)
+
+	classifyTags(context.Background(), inventory.NewProvider(loaded), chain, "group/project",
+		[]string{"main", "feature-gone", "latest-test", "3f2a9c1b", "a1b2c3d4"},
+		&types.ProjectContext{Path: "group/project"},
+		testPlan,
+	)
+
+	deleted := make(map[string]types.TagType)
+
+	for _, tag := range testPlan.Delete {
+		deleted[tag.Tag] = tag.TagType
+	}
+
+	// newest unknown tag is kept by unknown.minTags
+	need := map[string]types.TagType{
+		"feature-gone": types.BranchNotFound,
+		"3f2a9c1b":     types.UnknownExpired,
+		"a1b2c3d4":     types.UnknownExpired,
+	}
+
+	if len(deleted) != len(need) {
+		t.Fatalf("tags to delete %v need %v", deleted, need)
+	}
+
+	for tag, tagType := range need {
+		if deleted[tag] != tagType {
+			t.Fatalf("tags to delete %v need %v", deleted, need)
+		}
+	}
+}
+
+func TestAddMissingProjectTags(t *testing.T) {
+	projectsConfig := config.Get().Projects
+	defer func() { config.Get().Projects = projectsConfig }()
+
+	config.Get().Projects = config.Projects{
+		Missing:   config.ProjectsMissing{Enabled: true},
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+	}
+
+	repositories := []string{"group/removed/image", "denied/removed/image"}
+
+	result := inventory.New("docker")
+	// token can not read all projects of denied group
+	result.Groups = append(result.Groups, &inventory.Group{Path: "group", ReadProjects: true})
+
+	testPlan := plan.New("docker")
+
+	for _, repository := range repositories {
+		result.Repositories = append(result.Repositories, &inventory.Repository{
+			Repository: repository,
+			Tags:       []*inventory.Tag{{Tag: "main", Digest: testDigest}},
+		})
+
+		testPlan.Unmapped = append(testPlan.Unmapped, types.UnmappedRepository{
+			Repository: repository,
+			Projects:   api.GetGitlabProjectPaths(repository),
+		})
+	}
+
+	loaded := testInventory(t, result)
+
+	if err := addMissingProjectTags(context.Background(), inventory.NewProvider(loaded), newTagsCache(), loaded, repositories, testPlan); err != nil { //nolint:lll
+		t.Fatal(err)
+	}
+
+	if len(testPlan.Delete) != 1 || testPlan.Delete[0].Repository != "group/removed/image" || testPlan.Delete[0].TagType != types.ProjectMissing { //nolint:lll
+		t.Fatalf("tags to delete %+v must contain only tag of readable group", testPlan.Delete)
+	}
+
+	if len(testPlan.Unmapped) != 1 || testPlan.Unmapped[0].Repository != "denied/removed/image" || testPlan.Unmapped[0].MissingSince != nil { //nolint:lll
+		t.Fatalf("unmapped repositories %+v must contain repository of denied group", testPlan.Unmapped)
+	}
+
+	// plan does not change state
+	if _, err := os.Stat(config.Get().Projects.StateFile); !os.IsNotExist(err) {
+		t.Fatalf("state must not be saved on plan, %v", err)
+	}
+
+	if err := saveMissingProjects(testPlan); err != nil {
+		t.Fatal(err)
+	}
+
+	missingState, err := state.Load(config.Get().Projects.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := missingState.MissingSince["group/removed/image"]; !ok || len(missingState.MissingSince) != 1 {
+		t.Fatalf("state %+v must contain repository of readable group", missingState.MissingSince)
+	}
+}
+
+func TestAddTagDetailsDigestChanged(t *testing.T) {
+	t.Parallel()
+
+	result := inventory.New("docker")
+	result.Details = true
+	result.Repositories = append(result.Repositories, &inventory.Repository{
+		Repository: "group/project",
+		Tags: []*inventory.Tag{
+			{Tag: "feature", Digest: testDigest, Details: &inventory.Details{Size: 10}},
+			{Tag: "stale", Digest: testStaleDigest, Details: &inventory.Details{Size: 20}},
+		},
+	})
+
+	registry, ok := testProvider(t, result).(types.DetailsProvider)
+	if !ok {
+		t.Fatal("provider must return details")
+	}
+
+	testPlan := plan.New("docker")
+	testPlan.Delete = append(testPlan.Delete,
+		// tag was pushed again after classification
+		types.DeleteTagInput{Repository: "group/project", Tag: "feature", Digest: testStaleDigest, TagType: types.BranchNotFound}, //nolint:lll
+		types.DeleteTagInput{Repository: "group/project", Tag: "stale", Digest: testStaleDigest, TagType: types.BranchNotFound},   //nolint:lll
+	)
+
+	addTagDetails(context.Background(), registry, testPlan)
+
+	if len(testPlan.Delete) != 1 || testPlan.Delete[0].Tag != "stale" || testPlan.Delete[0].Size != 20 {
+		t.Fatalf("tags to delete %+v must contain only tag with the same digest", testPlan.Delete)
+	}
+
+	if len(testPlan.Keep) != 1 || testPlan.Keep[0].TagType != types.DigestChanged || testPlan.Keep[0].Digest != testDigest { //nolint:lll
+		t.Fatalf("tags to keep %+v must contain tag with changed digest", testPlan.Keep)
+	}
+}
+
+func TestLoadPlan(t *testing.T) {
+	mainConfig := *config.Get()
+	defer func() { *config.Get() = mainConfig }()
+
+	config.Get().Provider = "docker"
+	config.Get().Plan = filepath.Join(t.TempDir(), "plan.json")
+	config.Get().Kubernetes.Enabled = false
+
+	// registry after plan was created
+	result := inventory.New("docker")
+	result.Manifests = true
+	result.Repositories = append(result.Repositories, &inventory.Repository{
+		Repository: "group/project",
+		Tags: []*inventory.Tag{
+			{Tag: "stale", Digest: testStaleDigest},
+			// tag was pushed again
+			{Tag: "changed", Digest: testNewDigest},
+			// tag of deleted image was pushed after plan
+			{Tag: "shared", Digest: testDigest},
+			{Tag: "release", Digest: testDigest},
+		},
+	})
+
+	testPlan := plan.New("docker")
+	testPlan.Delete = append(testPlan.Delete,
+		types.DeleteTagInput{Repository: "group/project", Tag: "stale", Digest: testStaleDigest},
+		types.DeleteTagInput{Repository: "group/project", Tag: "changed", Digest: testDigest},
+		types.DeleteTagInput{Repository: "group/project", Tag: "shared", Digest: testDigest},
+		// tag was deleted after plan
+		types.DeleteTagInput{Repository: "group/project", Tag: "missing", Digest: testDigest},
+	)
+
+	if err := testPlan.Save(config.Get().Plan); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadPlan(context.Background(), testProvider(t, result))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.Delete) != 1 || loaded.Delete[0].Tag != "stale" {
+		t.Fatalf("tags to delete %+v must contain only not changed tag", loaded.Delete)
+	}
+
+	// plan of other provider is not applied
+	config.Get().Provider = "s3"
+
+	if _, err := loadPlan(context.Background(), testProvider(t, result)); err == nil {
+		t.Fatal("plan of other provider must return error")
+	}
+}
@@ -0,0 +1,317 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"context"
+	"sort"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/gitlab"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/inventory"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/metrics"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// load inventory that was exported for current provider.
+func loadInventory() (*inventory.Inventory, error) {
+	result, err := inventory.Load(config.Get().Inventory)
+	if err != nil {
+		return nil, errors.Wrap(err, config.Get().Inventory)
+	}
+
+	if result.Provider != config.Get().Provider {
+		return nil, errors.Errorf("inventory was exported for %s provider, current provider %s", result.Provider, config.Get().Provider) //nolint:lll
+	}
+
+	return result, nil
+}
+
+// export repositories, tags and gitlab projects of repositories to inventory file.
+func exportInventory(ctx context.Context, registry types.Provider, source gitlab.Source) error {
+	repositories, err := registry.Repositories(ctx, config.Get().Registry.Filter)
+	if err != nil {
+		return errors.Wrap(err, "can not list repositories")
+	}
+
+	_, hasDetails := registry.(types.DetailsProvider)
+	_, hasIndex := registry.(types.IndexProvider)
+
+	result := inventory.New(config.Get().Provider)
+	// details are read only when policy uses them
+	result.Details = hasDetails && (config.Get().Tag.Details || config.Get().Unknown.Enabled)
+	result.Index = hasIndex
+	result.Manifests = deletesManifest(registry)
+
+	// all project requests of resolver are exported to resolve projects in the same way on plan
+	resolver := newProjectResolver(source)
+
+	for _, repository := range repositories {
+		item, err := exportRepository(ctx, registry, repository, result.Details)
+		if err != nil {
+			return err
+		}
+
+		result.Repositories = append(result.Repositories, item)
+
+		if projectProvider, ok := registry.(types.ProjectProvider); ok {
+			// error is reported by resolver
+			item.Project, _ = projectProvider.Project(ctx, repository)
+		}
+
+		paths, err := resolver.candidates(ctx, registry, repository)
+		if err != nil {
+			metrics.TagsWarnings.Inc()
+			log.WithError(err).Warn()
+
+			continue
+		}
+
+		if _, err := resolver.resolve(ctx, paths); err != nil {
+			var unmapped *unmappedError
+
+			if errors.As(err, &unmapped) {
+				metrics.RepositoriesUnmapped.Inc()
+				log.WithError(err).Warnf("%s is not in any gitlab project", repository)
+
+				// error is exported with group
+				_, _ = resolver.canReadProjects(ctx, unmapped.paths)
+
+				continue
+			}
+
+			if err := exportError(repository, err); err != nil {
+				return err
+			}
+		}
+	}
+
+	// old and new paths of moved project are exported with the same data
+	exported := make(map[int]*inventory.Project)
+
+	for path, gitlabProject := range resolver.projects {
+		if gitlabProject == nil {
+			result.Projects = append(result.Projects, &inventory.Project{Path: path, NotFound: true})
+
+			continue
+		}
+
+		if item, ok := exported[gitlabProject.ID]; ok {
+			moved := *item
+			moved.Path = path
+
+			result.Projects = append(result.Projects, &moved)
+
+			continue
+		}
+
+		item, err := exportProject(ctx, source, path, gitlabProject)
+		if err != nil {
+			return err
+		}
+
+		exported[gitlabProject.ID] = item
+		result.Projects = append(result.Projects, item)
+	}
+
+	for path, err := range resolver.errs {
+		result.Projects = append(result.Projects, &inventory.Project{Path: path, Error: err.Error()})
+	}
+
+	for path, readProjects := range resolver.groups {
+		result.Groups = append(result.Groups, &inventory.Group{Path: path, ReadProjects: readProjects})
+	}
+
+	for path, err := range resolver.groupErrs {
+		result.Groups = append(result.Groups, &inventory.Group{Path: path, Error: err.Error()})
+	}
+
+	if err := result.Save(config.Get().Inventory); err != nil {
+		return errors.Wrap(err, "can not save inventory")
+	}
+
+	log.Infof("inventory saved to %s, repositories %d, projects %d",
+		config.Get().Inventory,
+		len(result.Repositories),
+		len(result.Projects),
+	)
+
+	return nil
+}
+
+// repository with failed request is exported with error or export is aborted with retry.onError.
+func exportError(name string, err error) error {
+	if config.Get().Retry.OnError == config.OnErrorAbort {
+		return errors.Wrap(err, name)
+	}
+
+	metrics.TagsErrors.Inc()
+	log.WithError(err).Errorf("%s is exported with error, it will be skipped by plan", name)
+
+	return nil
+}
+
+// export tags with digests and platform manifests of indexes.
+func exportRepository(ctx context.Context, registry types.Provider, repository string, details bool) (*inventory.Repository, error) { //nolint:lll
+	result := &inventory.Repository{
+		Repository: repository,
+		Tags:       make([]*inventory.Tag, 0),
+	}
+
+	tags, err := registry.Tags(ctx, repository)
+	if err != nil {
+		err = errors.Wrap(err, "can not list tags")
+
+		result.Error = err.Error()
+
+		return result, exportError(repository, err)
+	}
+
+	result.Tags = make([]*inventory.Tag, len(tags))
+
+	utils.Parallel(config.Get().Registry.Workers, len(tags), func(i int) {
+		result.Tags[i] = exportTag(ctx, registry, repository, tags[i], details)
+	})
+
+	indexProvider, ok := registry.(types.IndexProvider)
+	if !ok {
+		return result, nil
+	}
+
+	tagDigests := make(map[string]string)
+
+	for _, tag := range result.Tags {
+		if len(tag.Digest) > 0 {
+			tagDigests[tag.Tag] = tag.Digest
+		}
+	}
+
+	children, err := getIndexChildren(ctx, indexProvider, repository, tagDigests)
+	if err != nil {
+		// plan skips repository which manifests can not be read
+		metrics.TagsErrors.Inc()
+		log.WithError(err).Errorf("%s can not get manifests of index", repository)
+
+		return result, nil
+	}
+
+	result.Children = children
+
+	return result, nil
+}
+
+// export tag digest and details, tag without digest is kept by plan.
+func exportTag(ctx context.Context, registry types.Provider, repository, tag string, details bool) *inventory.Tag {
+	result := &inventory.Tag{Tag: tag}
+
+	if detailsProvider, ok := registry.(types.DetailsProvider); ok && details {
+		tagDetails, err := detailsProvider.TagDetails(ctx, repository, tag)
+		if err == nil {
+			result.Digest = tagDetails.Digest
+			result.Details = &inventory.Details{
+				MediaType: tagDetails.MediaType,
+				Created:   createdTime(tagDetails),
+				Size:      tagDetails.Size,
+			}
+
+			return result
+		}
+
+		metrics.TagsWarnings.Inc()
+		log.WithError(err).Warnf("%s:%s can not get tag details", repository, tag)
+	}
+
+	digest, err := registry.Digest(ctx, repository, tag)
+	if err != nil {
+		metrics.TagsErrors.Inc()
+		log.WithError(err).Errorf("%s:%s can not get digest", repository, tag)
+
+		return result
+	}
+
+	result.Digest = digest
+
+	return result
+}
+
+// export branches, open merge requests and deployments of gitlab project.
+func exportProject(ctx context.Context, source gitlab.Source, path string, gitlabProject *types.GitlabProject) (*inventory.Project, error) { //nolint:lll
+	result := &inventory.Project{
+		Path:              path,
+		ID:                gitlabProject.ID,
+		PathWithNamespace: gitlabProject.Path,
+		Archived:          gitlabProject.Archived,
+	}
+
+	if err := exportProjectData(ctx, source, result); err != nil {
+		result.Error = err.Error()
+
+		return result, exportError(path, err)
+	}
+
+	return result, nil
+}
+
+func exportProjectData(ctx context.Context, source gitlab.Source, project *inventory.Project) error {
+	branches, err := source.GetProjectBranches(ctx, project.ID)
+	if err != nil {
+		return errors.Wrap(err, "can not get branches")
+	}
+
+	for _, branch := range branches {
+		project.Branches = append(project.Branches, branch)
+	}
+
+	sort.Slice(project.Branches, func(i, j int) bool {
+		return project.Branches[i].Name < project.Branches[j].Name
+	})
+
+	mergeRequests, err := source.GetProjectOpenMergeRequests(ctx, project.ID)
+	if err != nil {
+		return errors.Wrap(err, "can not get merge requests")
+	}
+
+	for _, mergeRequest := range mergeRequests {
+		project.OpenMergeRequests = append(project.OpenMergeRequests, mergeRequest)
+	}
+
+	sort.Slice(project.OpenMergeRequests, func(i, j int) bool {
+		return project.OpenMergeRequests[i].IID < project.OpenMergeRequests[j].IID
+	})
+
+	deployments, err := source.GetProjectDeployments(ctx, project.ID)
+	if err != nil {
+		return errors.Wrap(err, "can not get deployments")
+	}
+
+	// deployment is listed by ref, sha and short sha
+	unique := make(map[*types.Deployment]bool)
+
+	for _, items := range deployments {
+		for _, deployment := range items {
+			if !unique[deployment] {
+				unique[deployment] = true
+				project.Deployments = append(project.Deployments, deployment)
+			}
+		}
+	}
+
+	sort.Slice(project.Deployments, func(i, j int) bool {
+		return project.Deployments[i].Environment < project.Deployments[j].Environment
+	})
+
+	return nil
+}
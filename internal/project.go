@@ -0,0 +1,304 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/api"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/gitlab"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/plan"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/state"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// gitlab project of registry repository.
+type project struct {
+	// requested path of project
+	Path string
+	types.GitlabProject
+}
+
+// path of project after renames and transfers, registry paths are lowercase.
+func (p *project) currentPath() string {
+	if len(p.GitlabProject.Path) == 0 || strings.EqualFold(p.GitlabProject.Path, p.Path) {
+		return p.Path
+	}
+
+	return strings.ToLower(p.GitlabProject.Path)
+}
+
+// project was renamed or transferred, gitlab redirects old path to project.
+func (p *project) moved() bool {
+	return p.currentPath() != p.Path
+}
+
+// resolve gitlab projects of repositories, results of project requests are cached.
+type projectResolver struct {
+	source gitlab.Source
+	// projects by path, nil if path is not a project
+	projects map[string]*types.GitlabProject
+	// failed project requests by path
+	errs map[string]error
+	// token can read all projects of root group
+	groups map[string]bool
+	// failed group requests by path
+	groupErrs map[string]error
+}
+
+// unmapped repository is not in any gitlab project.
+type unmappedError struct {
+	paths []string
+}
+
+func (e *unmappedError) Error() string {
+	if len(e.paths) == 0 {
+		return "path must contain group/project"
+	}
+
+	return fmt.Sprintf("projects %s not found", strings.Join(e.paths, ", "))
+}
+
+func newProjectResolver(source gitlab.Source) *projectResolver {
+	return &projectResolver{
+		source:    source,
+		projects:  make(map[string]*types.GitlabProject),
+		errs:      make(map[string]error),
+		groups:    make(map[string]bool),
+		groupErrs: make(map[string]error),
+	}
+}
+
+// get project of path once.
+func (r *projectResolver) lookup(ctx context.Context, path string) (*types.GitlabProject, error) {
+	if result, ok := r.projects[path]; ok {
+		return result, nil
+	}
+
+	if err, ok := r.errs[path]; ok {
+		return nil, err
+	}
+
+	result, err := r.source.GetProject(ctx, path)
+	if err != nil {
+		if !errors.Is(err, gitlab.ErrNotFound) {
+			r.errs[path] = err
+
+			return nil, err //nolint:wrapcheck
+		}
+	}
+
+	r.projects[path] = result
+
+	return result, nil
+}
+
+// token can read all projects of root group of paths, gitlab returns not found for projects
+// that token can not read, so projects are missing only in such groups.
+func (r *projectResolver) canReadProjects(ctx context.Context, paths []string) (bool, error) {
+	if len(paths) == 0 {
+		return false, nil
+	}
+
+	group := strings.Split(paths[len(paths)-1], "/")[0]
+
+	if result, ok := r.groups[group]; ok {
+		return result, nil
+	}
+
+	if err, ok := r.groupErrs[group]; ok {
+		return false, err
+	}
+
+	result, err := r.source.CanReadGroupProjects(ctx, group)
+	if err != nil {
+		r.groupErrs[group] = err
+
+		return false, err //nolint:wrapcheck
+	}
+
+	r.groups[group] = result
+
+	return result, nil
+}
+
+// possible project paths of repository from longest, explicit project from config
+// or path from provider is used if it is known, repository path can be rewritten by config.
+func (r *projectResolver) candidates(ctx context.Context, registry types.Provider, repository string) ([]string, error) {
+	if mapped := config.Get().GetRepositoryProject(repository); mapped != nil {
+		// project with explicit ID is not requested
+		if mapped.ProjectID > 0 {
+			r.projects[mapped.Project] = &types.GitlabProject{ID: mapped.ProjectID}
+		}
+
+		return []string{mapped.Project}, nil
+	}
+
+	if projectProvider, ok := registry.(types.ProjectProvider); ok {
+		path, err := projectProvider.Project(ctx, repository)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not get project of repository")
+		}
+
+		if len(path) > 0 {
+			return []string{path}, nil
+		}
+	}
+
+	return api.GetGitlabProjectPaths(config.Get().RewriteRepository(repository)), nil
+}
+
+// walk up paths until gitlab project is found.
+func (r *projectResolver) resolve(ctx context.Context, paths []string) (*project, error) {
+	for _, path := range paths {
+		result, err := r.lookup(ctx, path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can not get project %s", path)
+		}
+
+		if result != nil {
+			return &project{Path: path, GitlabProject: *result}, nil
+		}
+	}
+
+	return nil, &unmappedError{paths: paths}
+}
+
+// repository is ignored if any of its possible projects is ignored.
+func isIgnoredProject(paths []string) bool {
+	for _, path := range paths {
+		if ignoreRepositoryRegexp.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// delete tags of repositories which projects are not found longer than projects.missing.gracePeriod,
+// first run when project was not found is kept in state file.
+func addMissingProjectTags(ctx context.Context, registry types.Provider, cache *tagsCache, source gitlab.Source, repositories []string, result *plan.Plan) error { //nolint:lll,funlen,cyclop
+	missingConfig := config.Get().Projects.Missing
+	resolver := newProjectResolver(source)
+
+	missingState, err := state.Load(config.Get().Projects.StateFile)
+	if err != nil {
+		return errors.Wrap(err, config.Get().Projects.StateFile)
+	}
+
+	now := result.CreatedAt.UTC().Truncate(time.Second)
+	missing := make(map[string]bool)
+	unmapped := make([]types.UnmappedRepository, 0)
+
+	for _, repository := range result.Unmapped {
+		// repository without possible projects is never deleted
+		if len(repository.Projects) == 0 {
+			unmapped = append(unmapped, repository)
+
+			continue
+		}
+
+		readProjects, err := resolver.canReadProjects(ctx, repository.Projects)
+		if err != nil {
+			if err := skipProject(repository.Repository, errors.Wrap(err, "can not check access to group")); err != nil {
+				return err
+			}
+
+			// grace period of repository is kept
+			missing[repository.Repository] = true
+			unmapped = append(unmapped, repository)
+
+			continue
+		}
+
+		// project can exist, but token can not read it
+		if !readProjects {
+			log.Warnf("%s projects are not found, token can not read all projects of group, tags are not deleted", repository.Repository) //nolint:lll
+
+			unmapped = append(unmapped, repository)
+
+			continue
+		}
+
+		missing[repository.Repository] = true
+
+		since, ok := missingState.MissingSince[repository.Repository]
+		if !ok {
+			since = now
+			missingState.MissingSince[repository.Repository] = since
+		}
+
+		if now.Sub(since) < missingConfig.GracePeriod {
+			repository.MissingSince = &since
+			unmapped = append(unmapped, repository)
+
+			continue
+		}
+
+		tags, err := cache.list(ctx, registry, repository.Repository)
+		if err != nil {
+			if err := skipProject(repository.Repository, errors.Wrap(err, "can not list tags")); err != nil {
+				return err
+			}
+
+			repository.MissingSince = &since
+			unmapped = append(unmapped, repository)
+
+			continue
+		}
+
+		log.Warnf("%s project is not found since %s, its tags will be deleted", repository.Repository, since.Format(time.RFC3339)) //nolint:lll
+
+		for _, tag := range tags {
+			result.Delete = append(result.Delete, types.DeleteTagInput{
+				Repository: repository.Repository,
+				Tag:        tag,
+				TagType:    types.ProjectMissing,
+				Reason:     "project not found since " + since.Format(time.RFC3339),
+			})
+		}
+	}
+
+	result.Unmapped = unmapped
+
+	// project of repository was found again
+	for _, repository := range repositories {
+		if !missing[repository] {
+			delete(missingState.MissingSince, repository)
+		}
+	}
+
+	result.State = missingState
+
+	return nil
+}
+
+// save state of missing projects from plan, plan mode does not change state.
+func saveMissingProjects(result *plan.Plan) error {
+	// plan was created without missing projects
+	if result.State == nil {
+		return nil
+	}
+
+	if err := result.State.Save(config.Get().Projects.StateFile); err != nil {
+		return errors.Wrap(err, config.Get().Projects.StateFile)
+	}
+
+	return nil
+}
@@ -13,7 +13,6 @@ limitations under the License.
 package api
 
 import (
-	"flag"
 	"fmt"
 	"math"
 	"regexp"
@@ -21,21 +20,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/utils"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	tagArch             = flag.String("tag.arch", "amd64,arm64", "tag suffix for arch")
-	checkReleseTagDelta = flag.Int("ci.releases-delta-days", defaultCheckReleseTagDelta, "number of days allowed to be between release tag and commit date") //nolint:lll
-)
-
 const (
-	hoursInDay                  = 24
-	slashesInDockerRegistryPath = 3
-	defaultCheckReleseTagDelta  = 5
+	hoursInDay = 24
+	// gitlab project is at least group/project
+	minProjectPathSegments = 2
 )
 
 var version = "dev"
@@ -45,19 +40,17 @@ func GetVersion() string {
 	return version
 }
 
-// Convert docker registry path to gitlab path.
-func GetGitlabProjectPath(dockerRegistryPath string) (string, error) {
+// GetGitlabProjectPaths returns possible gitlab project paths of docker registry path from longest,
+// image can be pushed at project root (group/project) or nested in project (group/project/sub/image).
+func GetGitlabProjectPaths(dockerRegistryPath string) []string {
 	pathGroups := strings.Split(dockerRegistryPath, "/")
+	result := make([]string, 0, len(pathGroups))
 
-	if len(pathGroups) < slashesInDockerRegistryPath {
-		return "", errors.Errorf("path %s must contain group/project/image path", dockerRegistryPath)
-	}
-
-	if len(pathGroups) > 0 {
-		pathGroups = pathGroups[:len(pathGroups)-1]
+	for i := len(pathGroups); i >= minProjectPathSegments; i-- {
+		result = append(result, strings.Join(pathGroups[:i], "/"))
 	}
 
-	return strings.Join(pathGroups, "/"), nil
+	return result
 }
 
 type GetNotDeletableTagsInput struct {
@@ -65,6 +58,8 @@ type GetNotDeletableTagsInput struct {
 	DateRegexp       *regexp.Regexp
 	NotDeleteDays    float64
 	MinNotDeleteTags int
+	// time of plan, tags with date after it are not release tags
+	Now time.Time
 }
 
 // Detect stale tag.
@@ -75,7 +70,7 @@ func GetNotDeletableTags(input *GetNotDeletableTagsInput) []string { //nolint:fu
 
 	// Detect max release date
 	for tag := range input.Tags {
-		releaseTag, err := GetReleaseTag(input.DateRegexp, tag)
+		releaseTag, err := getReleaseTag(input.DateRegexp, tag, input.Now)
 		if err != nil {
 			log.WithError(err).Debug("not release tag")
 
@@ -94,7 +89,7 @@ func GetNotDeletableTags(input *GetNotDeletableTagsInput) []string { //nolint:fu
 	// Detect days between tag and maxrelease date
 	// if diff > 10 days - tag will be removed
 	for _, tag := range allTagDate {
-		releaseTag, err := GetReleaseTag(input.DateRegexp, tag)
+		releaseTag, err := getReleaseTag(input.DateRegexp, tag, input.Now)
 		if err != nil {
 			log.WithError(err).Error()
 
@@ -119,7 +114,7 @@ func GetNotDeletableTags(input *GetNotDeletableTagsInput) []string { //nolint:fu
 		tagsNotToDeleteMinimumDays := make(map[int64]bool)
 
 		for _, tagDateWOArch := range allTagDateWOArch {
-			releaseTag, err := GetReleaseTag(input.DateRegexp, tagDateWOArch)
+			releaseTag, err := getReleaseTag(input.DateRegexp, tagDateWOArch, input.Now)
 			if err != nil {
 				log.WithError(err).Error()
 
@@ -151,7 +146,7 @@ func GetNotDeletableTags(input *GetNotDeletableTagsInput) []string { //nolint:fu
 }
 
 func GetTagWithoutArch(tagName string) string {
-	tagArch := strings.Split(*tagArch, ",")
+	tagArch := strings.Split(config.Get().Tag.Arch, ",")
 
 	formatedTag := tagName
 
@@ -164,7 +159,7 @@ func GetTagWithoutArch(tagName string) string {
 }
 
 func GetTagsWithoutArch(tags []string) []string {
-	tagArch := strings.Split(*tagArch, ",")
+	tagArch := strings.Split(config.Get().Tag.Arch, ",")
 	result := make([]string, 0)
 
 	for _, tag := range tags {
@@ -189,6 +184,10 @@ type ReleaseTag struct {
 }
 
 func GetReleaseTag(tagNameRegexp *regexp.Regexp, tagName string) (*ReleaseTag, error) {
+	return getReleaseTag(tagNameRegexp, tagName, time.Now())
+}
+
+func getReleaseTag(tagNameRegexp *regexp.Regexp, tagName string, now time.Time) (*ReleaseTag, error) {
 	if !tagNameRegexp.MatchString(tagName) {
 		return nil, fmt.Errorf("tag %s doesn't match regexp", tagName) //nolint:goerr113
 	}
@@ -198,7 +197,7 @@ func GetReleaseTag(tagNameRegexp *regexp.Regexp, tagName string) (*ReleaseTag, e
 		return nil, errors.Wrap(err, "can not parse date")
 	}
 
-	if time.Since(tagDate) < 0 {
+	if now.Sub(tagDate) < 0 {
 		return nil, errors.New("tag date can not be in future") //nolint:goerr113
 	}
 
@@ -224,9 +223,202 @@ func CheckReleaseTag(tagNameRegexp *regexp.Regexp, tagName string, commitDate st
 
 	commitDateDiffDays := math.Abs(commitDateDiff.Hours() / hoursInDay)
 
-	if commitDateDiffDays > float64(*checkReleseTagDelta) {
-		return fmt.Errorf("difference between commit date and release bigger than %d days", *checkReleseTagDelta) //nolint:goerr113,lll
+	checkReleseTagDelta := config.Get().CI.ReleasesDeltaDays
+
+	if commitDateDiffDays > float64(checkReleseTagDelta) {
+		return fmt.Errorf("difference between commit date and release bigger than %d days", checkReleseTagDelta) //nolint:goerr113,lll
 	}
 
 	return nil
 }
+
+type GetExpiredTagsInput struct {
+	// creation time of tags, tags without creation time are never expired
+	Created          map[string]time.Time
+	NotDeleteDays    float64
+	MinNotDeleteTags int
+	// time of plan, tags are expired relative to it
+	Now time.Time
+}
+
+// Get tags that are created more than not delete days before now, newest tags are never expired.
+func GetExpiredTags(input *GetExpiredTagsInput) []string {
+	tags := make([]string, 0, len(input.Created))
+
+	for tag, created := range input.Created {
+		if !created.IsZero() {
+			tags = append(tags, tag)
+		}
+	}
+
+	// newest first
+	sort.Slice(tags, func(i, j int) bool {
+		if !input.Created[tags[i]].Equal(input.Created[tags[j]]) {
+			return input.Created[tags[i]].After(input.Created[tags[j]])
+		}
+
+		return tags[i] < tags[j]
+	})
+
+	result := make([]string, 0)
+
+	for i, tag := range tags {
+		if i < input.MinNotDeleteTags {
+			continue
+		}
+
+		if input.Now.Sub(input.Created[tag]).Hours()/hoursInDay > input.NotDeleteDays {
+			result = append(result, tag)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+type SharedDigestTag struct {
+	Tag types.DeleteTagInput
+	// kept tags with same manifest digest
+	KeptTags []string
+}
+
+// Filter tags of one repository which manifest digest is shared with kept tags,
+// deleting manifest by digest will remove all tags with this digest.
+func FilterSharedDigests(tagsToDelete []types.DeleteTagInput, tagDigests map[string]string) ([]types.DeleteTagInput, []SharedDigestTag) { //nolint:lll
+	deleteTags := make(map[string]bool)
+
+	for _, tag := range tagsToDelete {
+		deleteTags[tag.Tag] = true
+	}
+
+	keptDigests := make(map[string][]string)
+
+	for tag, digest := range tagDigests {
+		if !deleteTags[tag] {
+			keptDigests[digest] = append(keptDigests[digest], tag)
+		}
+	}
+
+	result := make([]types.DeleteTagInput, 0)
+	shared := make([]SharedDigestTag, 0)
+
+	for _, tag := range tagsToDelete {
+		tag.Digest = tagDigests[tag.Tag]
+
+		if keptTags, ok := keptDigests[tag.Digest]; ok {
+			sort.Strings(keptTags)
+
+			shared = append(shared, SharedDigestTag{
+				Tag:      tag,
+				KeptTags: keptTags,
+			})
+
+			continue
+		}
+
+		result = append(result, tag)
+	}
+
+	return result, shared
+}
+
+// Filter tags of one repository which manifest is a platform manifest of index of kept tags,
+// index and its platform manifests are one unit. Platform manifests of deleted index
+// that are not used by kept tags are set as children of deleted tag.
+func FilterIndexChildren(tagsToDelete []types.DeleteTagInput, tagDigests map[string]string, children map[string][]string) ([]types.DeleteTagInput, []SharedDigestTag) { //nolint:lll
+	deleteTags := make(map[string]bool)
+
+	for _, tag := range tagsToDelete {
+		deleteTags[tag.Tag] = true
+	}
+
+	keptDigests := make(map[string]bool)
+	keptChildren := make(map[string][]string)
+
+	for tag, digest := range tagDigests {
+		if deleteTags[tag] {
+			continue
+		}
+
+		keptDigests[digest] = true
+
+		for _, child := range children[digest] {
+			keptChildren[child] = append(keptChildren[child], tag)
+		}
+	}
+
+	result := make([]types.DeleteTagInput, 0)
+	shared := make([]SharedDigestTag, 0)
+
+	for _, tag := range tagsToDelete {
+		if keptTags, ok := keptChildren[tag.Digest]; ok {
+			sort.Strings(keptTags)
+
+			shared = append(shared, SharedDigestTag{
+				Tag:      tag,
+				KeptTags: keptTags,
+			})
+
+			continue
+		}
+
+		tag.Children = nil
+
+		for _, child := range children[tag.Digest] {
+			if _, ok := keptChildren[child]; ok || keptDigests[child] {
+				continue
+			}
+
+			tag.Children = append(tag.Children, child)
+		}
+
+		result = append(result, tag)
+	}
+
+	return result, shared
+}
+
+// tags of signatures, attestations and sboms that are pushed by cosign next to image.
+var referrerTagRegexp = regexp.MustCompile(`^(sha256|sha512)-([a-f0-9]+)\.(sig|att|sbom)$`)
+
+// Get subject manifest digest of referrer tag, sha256-<hex>.sig is referrer of sha256:<hex>.
+func GetReferrerSubject(tagName string) (string, bool) {
+	match := referrerTagRegexp.FindStringSubmatch(tagName)
+	if match == nil {
+		return "", false
+	}
+
+	return match[1] + ":" + match[2], true
+}
+
+type GetReferrerTagsInput struct {
+	// referrer tags of one repository
+	Tags []string
+	// manifests that are deleted in plan with their platform manifests
+	DeletedDigests map[string]bool
+	// manifests of kept tags with their platform manifests
+	KeptDigests map[string]bool
+	// delete tags which subject is not found in repository
+	DeleteOrphans bool
+}
+
+// Get referrer tags to delete, referrer tag inherits decision of its subject manifest.
+func GetReferrerTagsToDelete(input *GetReferrerTagsInput) map[string]types.TagType {
+	result := make(map[string]types.TagType)
+
+	for _, tag := range input.Tags {
+		subject, ok := GetReferrerSubject(tag)
+		if !ok || input.KeptDigests[subject] {
+			continue
+		}
+
+		if input.DeletedDigests[subject] {
+			result[tag] = types.ReferrerSubjectDeleted
+		} else if input.DeleteOrphans {
+			result[tag] = types.ReferrerSubjectNotFound
+		}
+	}
+
+	return result
+}
@@ -18,6 +18,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/api"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
@@ -29,6 +30,7 @@ func newReleaseInput(tags map[string]types.TagType) *api.GetNotDeletableTagsInpu
 		DateRegexp:       regexp.MustCompile(`^release-(\d{8}).*$`),
 		NotDeleteDays:    10,
 		MinNotDeleteTags: 3,
+		Now:              time.Now(),
 	}
 }
 
@@ -38,39 +40,24 @@ func newSnapshotnput(tags map[string]types.TagType) *api.GetNotDeletableTagsInpu
 		DateRegexp:       regexp.MustCompile(`^(\d{8})-snap$`),
 		NotDeleteDays:    10,
 		MinNotDeleteTags: 3,
+		Now:              time.Now(),
 	}
 }
 
-func TestGetGitlabProjectPath(t *testing.T) {
+func TestGetGitlabProjectPaths(t *testing.T) {
 	t.Parallel()
 
 	tests := make(map[string]string)
 
-	tests["test/test/test/test"] = "test/test/test"
-	tests["test/test/test"] = "test/test"
-	tests["#@/#@/&&"] = "#@/#@"
+	tests["test/test/test/test"] = "test/test/test/test,test/test/test,test/test"
+	tests["test/test/test"] = "test/test/test,test/test"
+	tests["test/test"] = "test/test"
+	tests["#@/#@/&&"] = "#@/#@/&&,#@/#@"
+	tests["test"] = ""
 
 	for in, out := range tests {
-		result, err := api.GetGitlabProjectPath(in)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		if result != out {
-			t.Fatalf("result %s need %s", result, out)
-		}
-	}
-
-	testsToFail := []string{
-		"test",
-		"test/test",
-		"test/test:test",
-	}
-
-	for _, test := range testsToFail {
-		_, err := api.GetGitlabProjectPath(test)
-		if err == nil {
-			t.Fatal("must throw error")
+		if result := strings.Join(api.GetGitlabProjectPaths(in), ","); result != out {
+			t.Fatalf("%s result %s need %s", in, result, out)
 		}
 	}
 }
@@ -359,3 +346,189 @@ func TestGetNotDeletableReleaseTagsRareFrequent(t *testing.T) {
 		t.Fatalf("tags not equals \n(%v)<=result\n(%v)<=need", result, need)
 	}
 }
+
+func TestFilterSharedDigests(t *testing.T) {
+	t.Parallel()
+
+	tagDigests := map[string]string{
+		"main":             "sha256:1",
+		"feature-promoted": "sha256:1",
+		"feature-old":      "sha256:2",
+		"feature-old-copy": "sha256:2",
+		"release-20230101": "sha256:3",
+	}
+
+	tagsToDelete := []types.DeleteTagInput{
+		{Repository: "group/project/image", Tag: "feature-promoted", TagType: types.BranchNotFound},
+		{Repository: "group/project/image", Tag: "feature-old", TagType: types.BranchStale},
+		{Repository: "group/project/image", Tag: "feature-old-copy", TagType: types.BranchStale},
+	}
+
+	result, shared := api.FilterSharedDigests(tagsToDelete, tagDigests)
+
+	if len(result) != 2 || result[0].Tag != "feature-old" || result[1].Tag != "feature-old-copy" {
+		t.Fatalf("result %+v is not correct", result)
+	}
+
+	if result[0].Digest != "sha256:2" {
+		t.Fatalf("digest %s must be sha256:2", result[0].Digest)
+	}
+
+	if len(shared) != 1 || shared[0].Tag.Tag != "feature-promoted" || !reflect.DeepEqual(shared[0].KeptTags, []string{"main"}) {
+		t.Fatalf("shared %+v is not correct", shared)
+	}
+}
+
+func TestGetExpiredTags(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	days := func(value int) time.Time {
+		return now.Add(-time.Duration(value) * 24 * time.Hour)
+	}
+
+	created := map[string]time.Time{
+		"latest-test": days(100),
+		"sha-1":       days(90),
+		"sha-2":       days(60),
+		"sha-3":       days(50),
+		"sha-4":       days(40),
+		"fresh":       days(1),
+		// image without config
+		"schema1": {},
+	}
+
+	result := api.GetExpiredTags(&api.GetExpiredTagsInput{
+		Created:          created,
+		NotDeleteDays:    30,
+		MinNotDeleteTags: 3,
+		Now:              now,
+	})
+
+	// 3 newest tags are kept even if they are older than 30 days
+	if need := []string{"latest-test", "sha-1", "sha-2"}; !reflect.DeepEqual(result, need) {
+		t.Fatalf("result %v need %v", result, need)
+	}
+
+	result = api.GetExpiredTags(&api.GetExpiredTagsInput{
+		Created:       created,
+		NotDeleteDays: 45,
+		Now:           now,
+	})
+
+	if need := []string{"latest-test", "sha-1", "sha-2", "sha-3"}; !reflect.DeepEqual(result, need) {
+		t.Fatalf("result %v need %v", result, need)
+	}
+
+	// tags are expired relative to time of plan
+	result = api.GetExpiredTags(&api.GetExpiredTagsInput{
+		Created:       created,
+		NotDeleteDays: 45,
+		Now:           days(30),
+	})
+
+	if need := []string{"latest-test", "sha-1"}; !reflect.DeepEqual(result, need) {
+		t.Fatalf("result %v need %v", result, need)
+	}
+}
+
+func TestFilterIndexChildren(t *testing.T) {
+	t.Parallel()
+
+	tagDigests := map[string]string{
+		"main":          "sha256:index1",
+		"main-amd64":    "sha256:amd64-1",
+		"feature":       "sha256:index2",
+		"feature-arm64": "sha256:arm64-2",
+		"release":       "sha256:amd64-3",
+	}
+
+	children := map[string][]string{
+		"sha256:index1": {"sha256:amd64-1", "sha256:arm64-1"},
+		"sha256:index2": {"sha256:amd64-2", "sha256:arm64-2", "sha256:amd64-3"},
+	}
+
+	tagsToDelete := []types.DeleteTagInput{
+		{Repository: "group/project/image", Tag: "main-amd64", TagType: types.BranchNotFound, Digest: "sha256:amd64-1"},
+		{Repository: "group/project/image", Tag: "feature", TagType: types.BranchStale, Digest: "sha256:index2"},
+		{Repository: "group/project/image", Tag: "feature-arm64", TagType: types.BranchStale, Digest: "sha256:arm64-2"},
+	}
+
+	result, shared := api.FilterIndexChildren(tagsToDelete, tagDigests, children)
+
+	if len(result) != 2 || result[0].Tag != "feature" || result[1].Tag != "feature-arm64" {
+		t.Fatalf("result %+v is not correct", result)
+	}
+
+	// platform manifest of release tag must be kept
+	if need := []string{"sha256:amd64-2", "sha256:arm64-2"}; !reflect.DeepEqual(result[0].Children, need) {
+		t.Fatalf("children %v need %v", result[0].Children, need)
+	}
+
+	if len(result[1].Children) != 0 {
+		t.Fatalf("image manifest %+v must not have children", result[1])
+	}
+
+	if len(shared) != 1 || shared[0].Tag.Tag != "main-amd64" || !reflect.DeepEqual(shared[0].KeptTags, []string{"main"}) {
+		t.Fatalf("shared %+v is not correct", shared)
+	}
+}
+
+func TestGetReferrerSubject(t *testing.T) {
+	t.Parallel()
+
+	hex := strings.Repeat("a", 64)
+
+	tests := map[string]string{
+		"sha256-" + hex + ".sig":  "sha256:" + hex,
+		"sha256-" + hex + ".att":  "sha256:" + hex,
+		"sha256-" + hex + ".sbom": "sha256:" + hex,
+		"sha256-" + hex:           "",
+		"sha256-" + hex + ".txt":  "",
+		"main":                    "",
+	}
+
+	for tag, need := range tests {
+		subject, ok := api.GetReferrerSubject(tag)
+		if subject != need || ok != (len(need) > 0) {
+			t.Fatalf("%s subject %s need %s", tag, subject, need)
+		}
+	}
+}
+
+func TestGetReferrerTagsToDelete(t *testing.T) {
+	t.Parallel()
+
+	referrerTag := func(value string, kind string) string {
+		return "sha256-" + strings.Repeat(value, 64) + "." + kind
+	}
+
+	input := &api.GetReferrerTagsInput{
+		Tags: []string{
+			referrerTag("1", "sig"),
+			referrerTag("2", "sig"),
+			referrerTag("2", "att"),
+			referrerTag("3", "sbom"),
+			"main",
+		},
+		DeletedDigests: map[string]bool{"sha256:" + strings.Repeat("2", 64): true},
+		KeptDigests:    map[string]bool{"sha256:" + strings.Repeat("1", 64): true},
+	}
+
+	need := map[string]types.TagType{
+		referrerTag("2", "sig"): types.ReferrerSubjectDeleted,
+		referrerTag("2", "att"): types.ReferrerSubjectDeleted,
+	}
+
+	if result := api.GetReferrerTagsToDelete(input); !reflect.DeepEqual(result, need) {
+		t.Fatalf("result %v need %v", result, need)
+	}
+
+	// subject of sbom is not found in repository
+	input.DeleteOrphans = true
+	need[referrerTag("3", "sbom")] = types.ReferrerSubjectNotFound
+
+	if result := api.GetReferrerTagsToDelete(input); !reflect.DeepEqual(result, need) {
+		t.Fatalf("result %v need %v", result, need)
+	}
+}
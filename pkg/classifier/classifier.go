@@ -0,0 +1,322 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package classifier
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/api"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+)
+
+// Chain runs classifiers in order, first classifier with decision wins.
+type Chain struct {
+	classifiers []types.Classifier
+}
+
+func NewChain(classifiers ...types.Classifier) *Chain {
+	return &Chain{
+		classifiers: classifiers,
+	}
+}
+
+// Create chain with user defined classifiers from config and built-in classifiers.
+func New(policy *config.Policy) *Chain {
+	classifiers := make([]types.Classifier, 0)
+
+	for _, item := range config.Get().Classifiers {
+		classifiers = append(classifiers, NewPattern(item))
+	}
+
+	classifiers = append(classifiers,
+		&Referrer{},
+		&System{Regexp: policy.SystemTag},
+		&Environment{},
+		&Release{Regexp: policy.ReleaseTag},
+		&MergeRequest{},
+		&Branch{Regexp: policy.BranchTag},
+	)
+
+	return NewChain(classifiers...)
+}
+
+// Create chain for archived project, only system tags, release tags in retention and referrers are kept.
+func NewArchived(policy *config.Policy) *Chain {
+	return NewChain(
+		&Referrer{},
+		&System{Regexp: policy.SystemTag},
+		&Archived{Regexp: policy.ReleaseTag},
+	)
+}
+
+func (c *Chain) Name() string {
+	return "chain"
+}
+
+func (c *Chain) Classify(input *types.ClassifierInput) *types.ClassifierResult {
+	for _, classifier := range c.classifiers {
+		if result := classifier.Classify(input); result != nil {
+			return result
+		}
+	}
+
+	return &types.ClassifierResult{
+		TagType: types.Unknown,
+		Reason:  "no classifier matched tag",
+	}
+}
+
+// Referrer tags of signatures, attestations and sboms are kept until decision of subject manifest.
+type Referrer struct{}
+
+func (c *Referrer) Name() string {
+	return "referrer"
+}
+
+func (c *Referrer) Classify(input *types.ClassifierInput) *types.ClassifierResult {
+	subject, ok := api.GetReferrerSubject(input.Tag)
+	if !ok {
+		return nil
+	}
+
+	return &types.ClassifierResult{
+		TagType: types.Referrer,
+		Reason:  "referrer of manifest " + subject,
+	}
+}
+
+// System tags are never deleted.
+type System struct {
+	Regexp *regexp.Regexp
+}
+
+func (c *System) Name() string {
+	return "system"
+}
+
+func (c *System) Classify(input *types.ClassifierInput) *types.ClassifierResult {
+	if !c.Regexp.MatchString(api.GetTagWithoutArch(input.Tag)) {
+		return nil
+	}
+
+	return &types.ClassifierResult{
+		TagType: types.SystemTag,
+		Reason:  fmt.Sprintf("tag matches system tag %s", c.Regexp.String()),
+	}
+}
+
+// Tags deployed to available environments are kept.
+type Environment struct{}
+
+func (c *Environment) Name() string {
+	return "environment"
+}
+
+func (c *Environment) Classify(input *types.ClassifierInput) *types.ClassifierResult {
+	deployments, ok := input.Project.Deployments[api.GetTagWithoutArch(input.Tag)]
+	if !ok {
+		return nil
+	}
+
+	environments := make([]string, 0, len(deployments))
+
+	for _, deployment := range deployments {
+		environments = append(environments, fmt.Sprintf("%s (%s)", deployment.Environment, deployment.Ref))
+	}
+
+	sort.Strings(environments)
+
+	return &types.ClassifierResult{
+		TagType: types.DeployedToEnvironment,
+		Reason:  "deployed to environments " + strings.Join(environments, ", "),
+	}
+}
+
+// Release tags are deleted if they are not in retention.
+type Release struct {
+	Regexp *regexp.Regexp
+}
+
+func (c *Release) Name() string {
+	return "release"
+}
+
+func (c *Release) Classify(input *types.ClassifierInput) *types.ClassifierResult {
+	if !c.Regexp.MatchString(input.Tag) {
+		return nil
+	}
+
+	if input.Project.ReleaseTagsNotToDelete[input.Tag] {
+		return &types.ClassifierResult{
+			TagType: types.ReleaseTagCanNotDelete,
+			Reason:  "release tag is in retention",
+		}
+	}
+
+	return &types.ClassifierResult{
+		TagType: types.ReleaseTag,
+		Reason:  "release tag is out of retention",
+		Delete:  true,
+	}
+}
+
+// Tags of archived project are deleted except release tags in retention.
+type Archived struct {
+	Regexp *regexp.Regexp
+}
+
+func (c *Archived) Name() string {
+	return "archived"
+}
+
+func (c *Archived) Classify(input *types.ClassifierInput) *types.ClassifierResult {
+	if c.Regexp.MatchString(input.Tag) && input.Project.ReleaseTagsNotToDelete[input.Tag] {
+		return &types.ClassifierResult{
+			TagType: types.ReleaseTagCanNotDelete,
+			Reason:  "latest release tag of archived project",
+		}
+	}
+
+	return &types.ClassifierResult{
+		TagType: types.ProjectArchived,
+		Reason:  fmt.Sprintf("project %s is archived", input.Project.Path),
+		Delete:  true,
+	}
+}
+
+// Branch tags with open merge request are kept.
+type MergeRequest struct{}
+
+func (c *MergeRequest) Name() string {
+	return "merge-request"
+}
+
+func (c *MergeRequest) Classify(input *types.ClassifierInput) *types.ClassifierResult {
+	mergeRequest, ok := input.Project.OpenMergeRequests[api.GetTagWithoutArch(input.Tag)]
+	if !ok {
+		return nil
+	}
+
+	reason := fmt.Sprintf("branch %s has open merge request !%d", mergeRequest.SourceBranch, mergeRequest.IID)
+	if mergeRequest.Fork {
+		reason += " from fork"
+	}
+
+	return &types.ClassifierResult{
+		TagType: types.BranchOpenMergeRequest,
+		Reason:  reason,
+	}
+}
+
+// Branch tags are deleted if branch not found or branch is staled,
+// tag without branch that does not match regexp has no decision.
+type Branch struct {
+	Regexp *regexp.Regexp
+}
+
+func (c *Branch) Name() string {
+	return "branch"
+}
+
+func (c *Branch) Classify(input *types.ClassifierInput) *types.ClassifierResult {
+	tagWithoutArch := api.GetTagWithoutArch(input.Tag)
+
+	branch, ok := input.Project.Branches[tagWithoutArch]
+	if !ok {
+		if c.Regexp != nil && !c.Regexp.MatchString(tagWithoutArch) {
+			return nil
+		}
+
+		return &types.ClassifierResult{
+			TagType: types.BranchNotFound,
+			Reason:  fmt.Sprintf("branch %s not found in %s", tagWithoutArch, input.Project.Path),
+			Delete:  true,
+		}
+	}
+
+	if branch.IsStaled(input.Project.Now, input.Project.BranchStaleDays) {
+		return &types.ClassifierResult{
+			TagType: types.BranchStale,
+			Reason:  fmt.Sprintf("branch %s has last commit more than %d days ago", branch.Name, input.Project.BranchStaleDays),
+			Delete:  true,
+		}
+	}
+
+	return &types.ClassifierResult{
+		TagType: types.BranchNotStaled,
+		Reason:  fmt.Sprintf("branch %s has last commit less than %d days ago", branch.Name, input.Project.BranchStaleDays),
+	}
+}
+
+// Pattern is user defined classifier.
+type Pattern struct {
+	name       string
+	repository *regexp.Regexp
+	project    *regexp.Regexp
+	tag        *regexp.Regexp
+	tagType    types.TagType
+	delete     bool
+	reason     string
+}
+
+func NewPattern(item config.Classifier) *Pattern {
+	pattern := Pattern{
+		name:    item.Name,
+		tag:     regexp.MustCompile(item.Tag),
+		tagType: types.TagType(item.TagType),
+		delete:  item.Action == config.ClassifierActionDelete,
+		reason:  item.Reason,
+	}
+
+	if len(item.Repository) > 0 {
+		pattern.repository = regexp.MustCompile(item.Repository)
+	}
+
+	if len(item.Project) > 0 {
+		pattern.project = regexp.MustCompile(item.Project)
+	}
+
+	if len(pattern.reason) == 0 {
+		pattern.reason = fmt.Sprintf("tag matches classifier %s", item.Name)
+	}
+
+	return &pattern
+}
+
+func (c *Pattern) Name() string {
+	return c.name
+}
+
+func (c *Pattern) Classify(input *types.ClassifierInput) *types.ClassifierResult {
+	if c.repository != nil && !c.repository.MatchString(input.Repository) {
+		return nil
+	}
+
+	if c.project != nil && !c.project.MatchString(input.Project.Path) {
+		return nil
+	}
+
+	if !c.tag.MatchString(api.GetTagWithoutArch(input.Tag)) {
+		return nil
+	}
+
+	return &types.ClassifierResult{
+		TagType: c.tagType,
+		Reason:  c.reason,
+		Delete:  c.delete,
+	}
+}
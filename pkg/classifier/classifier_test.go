@@ -0,0 +1,217 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package classifier_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/classifier"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+)
+
+func newProjectContext() *types.ProjectContext {
+	return &types.ProjectContext{
+		Path: "group/project",
+		Now:  time.Now(),
+		Branches: map[string]*types.Branch{
+			"main":          {Name: "main", LastCommitDate: time.Now()},
+			"feature-new":   {Name: "feature/new", LastCommitDate: time.Now()},
+			"feature-old":   {Name: "feature/old", LastCommitDate: time.Now().Add(-60 * 24 * time.Hour)},
+			"feature-stale": {Name: "feature/stale", LastCommitDate: time.Now().Add(-60 * 24 * time.Hour)},
+		},
+		OpenMergeRequests: map[string]*types.MergeRequest{
+			"feature-review": {IID: 1, SourceBranch: "feature/review"},
+			"fork-feature":   {IID: 2, SourceBranch: "fork/feature", Fork: true},
+		},
+		Deployments: map[string][]*types.Deployment{
+			"feature-old":      {{Environment: "staging", Ref: "feature/old"}},
+			"release-20230101": {{Environment: "production", Ref: "release-20230101"}},
+			"1234abcd":         {{Environment: "review/test", Ref: "test", SHA: "1234abcdef"}},
+		},
+		BranchStaleDays: 30,
+		ReleaseTagsNotToDelete: map[string]bool{
+			"release-20230616": true,
+		},
+	}
+}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	chain := classifier.NewChain(
+		classifier.NewPattern(config.Classifier{
+			Name:       "hotfix",
+			Repository: "^group/project/.+$",
+			Tag:        "^hotfix-.+$",
+			TagType:    "Hotfix",
+			Action:     config.ClassifierActionKeep,
+		}),
+		classifier.NewPattern(config.Classifier{
+			Name:    "mr",
+			Tag:     "^mr-.+$",
+			TagType: "MergeRequest",
+			Action:  config.ClassifierActionDelete,
+		}),
+		&classifier.Referrer{},
+		&classifier.System{Regexp: regexp.MustCompile(`^(main|master)$`)},
+		&classifier.Environment{},
+		&classifier.Release{Regexp: regexp.MustCompile(`^release-(\d{8}).*$`)},
+		&classifier.MergeRequest{},
+		&classifier.Branch{},
+	)
+
+	type Test struct {
+		Repository string
+		TagType    types.TagType
+		Delete     bool
+	}
+
+	tests := make(map[string]Test)
+
+	tests["main"] = Test{"group/project/image", types.SystemTag, false}
+	tests["main-arm64"] = Test{"group/project/image", types.SystemTag, false}
+	tests["release-20230616"] = Test{"group/project/image", types.ReleaseTagCanNotDelete, false}
+	tests["release-20230102"] = Test{"group/project/image", types.ReleaseTag, true}
+	tests["release-20230101"] = Test{"group/project/image", types.DeployedToEnvironment, false}
+	tests["feature-old-arm64"] = Test{"group/project/image", types.DeployedToEnvironment, false}
+	tests["1234abcd"] = Test{"group/project/image", types.DeployedToEnvironment, false}
+	tests["feature-new"] = Test{"group/project/image", types.BranchNotStaled, false}
+	tests["feature-stale-amd64"] = Test{"group/project/image", types.BranchStale, true}
+	tests["feature-removed"] = Test{"group/project/image", types.BranchNotFound, true}
+	tests["feature-review"] = Test{"group/project/image", types.BranchOpenMergeRequest, false}
+	tests["fork-feature-arm64"] = Test{"group/project/image", types.BranchOpenMergeRequest, false}
+	tests["hotfix-1"] = Test{"group/project/image", "Hotfix", false}
+	tests["mr-1"] = Test{"group/project/image", "MergeRequest", true}
+	tests["sha256-"+strings.Repeat("a", 64)+".sig"] = Test{"group/project/image", types.Referrer, false}
+
+	for tag, test := range tests {
+		result := chain.Classify(&types.ClassifierInput{
+			Repository: test.Repository,
+			Tag:        tag,
+			Project:    newProjectContext(),
+		})
+
+		if result.TagType != test.TagType || result.Delete != test.Delete {
+			t.Fatalf("%s result %+v need %+v", tag, result, test)
+		}
+
+		if len(result.Reason) == 0 {
+			t.Fatalf("%s reason must be set", tag)
+		}
+	}
+
+	// hotfix classifier works only in matched repository
+	result := chain.Classify(&types.ClassifierInput{
+		Repository: "group/other",
+		Tag:        "hotfix-1",
+		Project:    newProjectContext(),
+	})
+
+	if result.TagType != types.BranchNotFound {
+		t.Fatalf("result %+v must be %s", result, types.BranchNotFound)
+	}
+}
+
+func TestChainUnknown(t *testing.T) {
+	t.Parallel()
+
+	result := classifier.NewChain().Classify(&types.ClassifierInput{
+		Repository: "group/project/image",
+		Tag:        "test",
+		Project:    newProjectContext(),
+	})
+
+	if result.TagType != types.Unknown || result.Delete {
+		t.Fatalf("result %+v must be %s", result, types.Unknown)
+	}
+}
+
+func TestNewUnknown(t *testing.T) {
+	t.Parallel()
+
+	policy := &config.Policy{
+		SystemTag:  regexp.MustCompile(`^(main|master)$`),
+		ReleaseTag: regexp.MustCompile(`^release-(\d{8}).*$`),
+	}
+
+	type Test struct {
+		BranchTag *regexp.Regexp
+		TagType   types.TagType
+		Delete    bool
+	}
+
+	branchTag := regexp.MustCompile(`^(feature|fix)-.+$`)
+
+	tests := make(map[string]Test)
+
+	// all tags are branch tags by default
+	tests["latest-test"] = Test{nil, types.BranchNotFound, true}
+	tests["feature-gone"] = Test{branchTag, types.BranchNotFound, true}
+	tests["feature-new"] = Test{branchTag, types.BranchNotStaled, false}
+	tests["latest-test-amd64"] = Test{branchTag, types.Unknown, false}
+	tests["3f2a9c1b"] = Test{branchTag, types.Unknown, false}
+
+	for tag, test := range tests {
+		policy := *policy
+		policy.BranchTag = test.BranchTag
+
+		result := classifier.New(&policy).Classify(&types.ClassifierInput{
+			Repository: "group/project/image",
+			Tag:        tag,
+			Project:    newProjectContext(),
+		})
+
+		if result.TagType != test.TagType || result.Delete != test.Delete {
+			t.Fatalf("%s result %+v need %+v", tag, result, test)
+		}
+	}
+}
+
+func TestArchived(t *testing.T) {
+	t.Parallel()
+
+	chain := classifier.NewArchived(&config.Policy{
+		SystemTag:  regexp.MustCompile(`^(main|master)$`),
+		ReleaseTag: regexp.MustCompile(`^release-(\d{8}).*$`),
+	})
+
+	type Test struct {
+		TagType types.TagType
+		Delete  bool
+	}
+
+	tests := make(map[string]Test)
+
+	tests["main-arm64"] = Test{types.SystemTag, false}
+	tests["release-20230616"] = Test{types.ReleaseTagCanNotDelete, false}
+	tests["release-20230101"] = Test{types.ProjectArchived, true}
+	tests["feature-new"] = Test{types.ProjectArchived, true}
+	tests["feature-review"] = Test{types.ProjectArchived, true}
+	tests["sha256-"+strings.Repeat("a", 64)+".sig"] = Test{types.Referrer, false}
+
+	for tag, test := range tests {
+		result := chain.Classify(&types.ClassifierInput{
+			Repository: "group/project/image",
+			Tag:        tag,
+			Project:    newProjectContext(),
+		})
+
+		if result.TagType != test.TagType || result.Delete != test.Delete {
+			t.Fatalf("%s result %+v need %+v", tag, result, test)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	ClassifierActionKeep   = "keep"
+	ClassifierActionDelete = "delete"
+)
+
+// Classifier is user defined tag classifier, it runs before built-in classifiers.
+type Classifier struct {
+	Name string `yaml:"name"`
+	// docker repository regexp
+	Repository string `yaml:"repository"`
+	// gitlab project path regexp
+	Project string `yaml:"project"`
+	// tag without arch suffix regexp
+	Tag     string `yaml:"tag"`
+	TagType string `yaml:"tagType"`
+	// keep or delete
+	Action string `yaml:"action"`
+	Reason string `yaml:"reason"`
+}
+
+func (c *Classifier) validate(prefix string) error {
+	if len(c.Name) == 0 {
+		return errors.Errorf("%s.name: must be set", prefix)
+	}
+
+	if len(c.Tag) == 0 {
+		return errors.Errorf("%s.tag: must be set", prefix)
+	}
+
+	if err := validateRegexp(prefix+".tag", c.Tag, 0); err != nil {
+		return err
+	}
+
+	if err := validateRegexp(prefix+".repository", c.Repository, 0); err != nil {
+		return err
+	}
+
+	if err := validateRegexp(prefix+".project", c.Project, 0); err != nil {
+		return err
+	}
+
+	if len(c.TagType) == 0 {
+		return errors.Errorf("%s.tagType: must be set", prefix)
+	}
+
+	switch c.Action {
+	case ClassifierActionKeep, ClassifierActionDelete:
+	default:
+		return errors.Errorf("%s.action: must be %s or %s, got %q", prefix, ClassifierActionKeep, ClassifierActionDelete, c.Action) //nolint:lll
+	}
+
+	return nil
+}
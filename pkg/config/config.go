@@ -0,0 +1,679 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultNotDeleteDays       = 10
+	defaultMinNotDeleteTags    = 3
+	defaultStaleBranchDays     = 30
+	defaultCheckReleseTagDelta = 5
+	defaultListWorkers         = 10
+	defaultWorkers             = 1
+	defaultGCMinAge            = 24 * time.Hour
+	defaultUploadsMaxAge       = 24 * time.Hour
+	defaultUnknownDays         = 30
+	defaultMaxAttempts         = 5
+	defaultInitialBackoff      = time.Second
+	defaultMaxBackoff          = 30 * time.Second
+	defaultMissingGracePeriod  = 30 * 24 * time.Hour
+)
+
+type Gitlab struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+	// comma separated groups with registry repositories for gitlab provider
+	Groups string `yaml:"groups"`
+}
+
+type Registry struct {
+	// filter repositories by regexp
+	Filter string `yaml:"filter"`
+	// ignore gitlab projects by regexp
+	Ignore string `yaml:"ignore"`
+	// concurrent requests of listing digests and deleting tags
+	Workers int `yaml:"workers"`
+	// requests per second of all providers and gitlab, 0 is unlimited
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+}
+
+type Docker struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Wait     bool   `yaml:"wait"`
+}
+
+// Storage layout of registry in object storage.
+type Storage struct {
+	RegistryFolder string `yaml:"registryFolder"`
+	BlobsFolder    string `yaml:"blobsFolder"`
+	// concurrent list requests
+	ListWorkers int       `yaml:"listWorkers"`
+	GC          StorageGC `yaml:"gc"`
+	// uploads that are started later are not deleted
+	UploadsMaxAge time.Duration `yaml:"uploadsMaxAge"`
+}
+
+type StorageGC struct {
+	// delete blobs that are not referenced by manifests
+	Enabled bool `yaml:"enabled"`
+	// blobs and links that are modified later are not deleted
+	MinAge time.Duration `yaml:"minAge"`
+	// delete manifests without tags, except referrers of kept manifests
+	DeleteUntagged bool `yaml:"deleteUntagged"`
+}
+
+type S3 struct {
+	Region         string `yaml:"region"`
+	AccessKey      string `yaml:"accessKey"`
+	SecretKey      string `yaml:"secretKey"`
+	Bucket         string `yaml:"bucket"`
+	Endpoint       string `yaml:"endpoint"`
+	DisableSSL     bool   `yaml:"disableSSL"`
+	ForcePathStyle bool   `yaml:"forcePathStyle"`
+	// deprecated, use storage.registryFolder
+	RegistryFolder string `yaml:"registryFolder"`
+}
+
+type GCS struct {
+	Bucket string `yaml:"bucket"`
+	// endpoint of emulator, credentials are not used
+	Endpoint string `yaml:"endpoint"`
+}
+
+type Azure struct {
+	AccountName      string `yaml:"accountName"`
+	AccountKey       string `yaml:"accountKey"`
+	ConnectionString string `yaml:"connectionString"`
+	Container        string `yaml:"container"`
+	// blob service endpoint, https://<accountName>.blob.core.windows.net if empty
+	Endpoint string `yaml:"endpoint"`
+}
+
+type Filesystem struct {
+	// path to registry storage root
+	Root string `yaml:"root"`
+}
+
+type Metrics struct {
+	Job         string `yaml:"job"`
+	PushGateway string `yaml:"pushgateway"`
+}
+
+type Retention struct {
+	// regexp with date YYYYMMDD in first group
+	Tag           string  `yaml:"tag"`
+	DaysNotDelete float64 `yaml:"daysNotDelete"`
+	MinTags       int     `yaml:"minTags"`
+}
+
+type System struct {
+	Tag string `yaml:"tag"`
+}
+
+type Snapshot struct {
+	Enabled    bool   `yaml:"enabled"`
+	Repository string `yaml:"repository"`
+	Retention  `yaml:",inline"`
+}
+
+type Branch struct {
+	// branch is staled if last commit more than this days ago
+	StaleDays int `yaml:"staleDays"`
+	// keep images of branches with open merge requests
+	KeepOpenMergeRequests bool `yaml:"keepOpenMergeRequests"`
+	// regexp of branch tags, tags without branch that do not match it are unknown, all tags are branch tags if empty
+	Tag string `yaml:"tag"`
+}
+
+type Unknown struct {
+	// delete tags that match no classifier when image is older than daysNotDelete
+	Enabled       bool    `yaml:"enabled"`
+	DaysNotDelete float64 `yaml:"daysNotDelete"`
+	// newest tags of repository that are never deleted
+	MinTags int `yaml:"minTags"`
+}
+
+const (
+	OnErrorSkip  = "skip"
+	OnErrorAbort = "abort"
+)
+
+type Retry struct {
+	// attempts of request, 1 disables retries
+	MaxAttempts    int           `yaml:"maxAttempts"`
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+	// skip project or abort run when gitlab or registry request fails after all attempts
+	OnError string `yaml:"onError"`
+}
+
+type Environments struct {
+	// keep images of last successful deployments to available environments
+	KeepDeployed bool `yaml:"keepDeployed"`
+}
+
+type Kubernetes struct {
+	// keep images that are used in kubernetes clusters
+	Enabled bool `yaml:"enabled"`
+	// path to kubeconfig, in-cluster config is used if empty and no contexts set
+	Kubeconfig string `yaml:"kubeconfig"`
+	// comma separated kubeconfig contexts
+	Contexts string `yaml:"contexts"`
+	// use only images from this registry host
+	Registry string `yaml:"registry"`
+	// continue without images of clusters that can not be read, plan is aborted by default
+	IgnoreErrors bool `yaml:"ignoreErrors"`
+}
+
+type Tag struct {
+	// comma separated tag suffixes for arch
+	Arch string `yaml:"arch"`
+	// add digest, media type, creation time and size of tags to plan
+	Details bool `yaml:"details"`
+}
+
+type CI struct {
+	Check             bool   `yaml:"check"`
+	Tag               string `yaml:"tag"`
+	CommitDate        string `yaml:"commitDate"`
+	ReleasesDeltaDays int    `yaml:"releasesDeltaDays"`
+}
+
+const (
+	ModeRun   = "run"
+	ModePlan  = "plan"
+	ModeApply = "apply"
+	// export repositories, tags and gitlab projects to inventory file
+	ModeExport = "export"
+)
+
+type Type struct {
+	// run, plan, apply or export
+	Mode string `yaml:"mode"`
+	// path to plan file
+	Plan string `yaml:"plan"`
+	// path to inventory file, plan mode reads it instead of registry and gitlab
+	Inventory    string       `yaml:"inventory"`
+	Provider     string       `yaml:"provider"`
+	DryRun       bool         `yaml:"dryRun"`
+	Gitlab       Gitlab       `yaml:"gitlab"`
+	Registry     Registry     `yaml:"registry"`
+	Docker       Docker       `yaml:"docker"`
+	Storage      Storage      `yaml:"storage"`
+	S3           S3           `yaml:"s3"`
+	GCS          GCS          `yaml:"gcs"`
+	Azure        Azure        `yaml:"azure"`
+	Filesystem   Filesystem   `yaml:"filesystem"`
+	Metrics      Metrics      `yaml:"metrics"`
+	Release      Retention    `yaml:"release"`
+	System       System       `yaml:"system"`
+	Snapshot     Snapshot     `yaml:"snapshot"`
+	Branch       Branch       `yaml:"branch"`
+	Unknown      Unknown      `yaml:"unknown"`
+	Retry        Retry        `yaml:"retry"`
+	Environments Environments `yaml:"environments"`
+	Kubernetes   Kubernetes   `yaml:"kubernetes"`
+	Tag          Tag          `yaml:"tag"`
+	CI           CI           `yaml:"ci"`
+	Projects     Projects     `yaml:"projects"`
+	Rules        []Rule       `yaml:"rules"`
+	// user defined tag classifiers
+	Classifiers []Classifier `yaml:"classifiers"`
+
+	// patterns are compiled after validation
+	releaseTagRegexp *regexp.Regexp
+	systemTagRegexp  *regexp.Regexp
+	branchTagRegexp  *regexp.Regexp
+}
+
+var config = Type{}
+
+// flag name to env variable name, env variables override values from config file.
+var flagEnv = make(map[string]string)
+
+var configFile = flag.String("config", os.Getenv("CONFIG"), "path to policy file (yaml or json)")
+
+func stringVar(p *string, name, env, value, usage string) {
+	if len(env) > 0 {
+		flagEnv[name] = env
+		value = utils.GetEnv(env, value)
+	}
+
+	flag.StringVar(p, name, value, usage)
+}
+
+func boolVar(p *bool, name, env string, value bool, usage string) {
+	if len(env) > 0 {
+		flagEnv[name] = env
+
+		if v, ok := os.LookupEnv(env); ok {
+			value = v == "true"
+		}
+	}
+
+	flag.BoolVar(p, name, value, usage)
+}
+
+func init() { //nolint:gochecknoinits
+	stringVar(&config.Mode, "mode", "", ModeRun, "run, plan, apply or export, can be set as first argument")
+	stringVar(&config.Plan, "plan", "", "", "path to plan file")
+	stringVar(&config.Inventory, "inventory", "", "", "path to inventory file (json or ndjson)")
+	stringVar(&config.Provider, "provider", "", "docker", "registry provider: docker, gitlab, s3, gcs, azure, filesystem")
+	boolVar(&config.DryRun, "dry-run", "", false, "")
+
+	stringVar(&config.Gitlab.Token, "gitlab.token", "GITLAB_TOKEN", "", "")
+	stringVar(&config.Gitlab.URL, "gitlab.url", "GITLAB_URL", "", "")
+	stringVar(&config.Gitlab.Groups, "gitlab.groups", "", "", "comma separated groups for gitlab provider, projects of token are used if empty") //nolint:lll
+
+	stringVar(&config.Registry.Filter, "registry.filter", "", "", "")
+	stringVar(&config.Registry.Ignore, "ignoreTags", "IGNORE_TAGS", `^devops/docker$`, "")
+	flag.IntVar(&config.Registry.Workers, "registry.workers", defaultWorkers, "concurrent listing and deleting requests")
+	flag.Float64Var(&config.Registry.RequestsPerSecond, "registry.requests-per-second", 0, "limit of requests per second to registry, storage and gitlab, 0 is unlimited") //nolint:lll
+
+	boolVar(&config.Docker.Wait, "registry-wait", "", false, "")
+	stringVar(&config.Docker.URL, "registry.url", "REGISTRY_URL", "http://127.0.0.1:5000", "format https://registry.com")
+	stringVar(&config.Docker.Username, "registry.username", "REGISTRY_USERNAME", "", "")
+	stringVar(&config.Docker.Password, "registry.password", "REGISTRY_PASSWORD", "", "")
+
+	stringVar(&config.S3.Region, "s3.region", "S3_REGION", "", "")
+	stringVar(&config.S3.AccessKey, "s3.accesskey", "S3_ACCESSKEY", "", "")
+	stringVar(&config.S3.SecretKey, "s3.secretkey", "S3_SECRETKEY", "", "")
+	stringVar(&config.S3.Bucket, "s3.bucket", "S3_BUCKET", "", "")
+	stringVar(&config.S3.Endpoint, "s3.endpoint", "S3_ENDPOINT", "", "")
+	boolVar(&config.S3.DisableSSL, "s3.disable-ssl", "S3_DISABLE_SSL", false, "")
+	boolVar(&config.S3.ForcePathStyle, "s3.force-path-style", "S3_FORCE_PATH_STYLE", false, "")
+	stringVar(&config.S3.RegistryFolder, "s3.registry-folder", "", "", "deprecated, use -storage.registry-folder")
+
+	stringVar(&config.Storage.RegistryFolder, "storage.registry-folder", "", "docker/registry/v2/repositories/", "")
+	stringVar(&config.Storage.BlobsFolder, "storage.blobs-folder", "", "docker/registry/v2/blobs/", "")
+	flag.IntVar(&config.Storage.ListWorkers, "storage.list-workers", defaultListWorkers, "concurrent list requests")
+	flag.DurationVar(&config.Storage.UploadsMaxAge, "storage.uploads-max-age", defaultUploadsMaxAge, "delete uploads that are started more than this time ago")              //nolint:lll
+	boolVar(&config.Storage.GC.Enabled, "storage.gc", "", false, "delete blobs that are not referenced by manifests, registry must be read-only during garbage collection")  //nolint:lll
+	flag.DurationVar(&config.Storage.GC.MinAge, "storage.gc-min-age", defaultGCMinAge, "blobs and links that are modified later and blobs with later links are not deleted") //nolint:lll
+	boolVar(&config.Storage.GC.DeleteUntagged, "storage.gc-delete-untagged", "", false, "delete manifests without tags, images pulled by digest are deleted too")            //nolint:lll
+
+	stringVar(&config.GCS.Bucket, "gcs.bucket", "GCS_BUCKET", "", "")
+	stringVar(&config.GCS.Endpoint, "gcs.endpoint", "GCS_ENDPOINT", "", "endpoint of emulator, for example http://localhost:4443")
+
+	stringVar(&config.Azure.AccountName, "azure.account-name", "AZURE_STORAGE_ACCOUNT", "", "")
+	stringVar(&config.Azure.AccountKey, "azure.account-key", "AZURE_STORAGE_KEY", "", "")
+	stringVar(&config.Azure.ConnectionString, "azure.connection-string", "AZURE_STORAGE_CONNECTION_STRING", "", "")
+	stringVar(&config.Azure.Container, "azure.container", "AZURE_STORAGE_CONTAINER", "", "")
+	stringVar(&config.Azure.Endpoint, "azure.endpoint", "AZURE_STORAGE_ENDPOINT", "", "blob service endpoint")
+
+	stringVar(&config.Filesystem.Root, "filesystem.root", "", "/var/lib/registry", "path to registry storage root")
+
+	stringVar(&config.Metrics.Job, "metrics.job", "", "gitlab_registry_cleaner", "")
+	stringVar(&config.Metrics.PushGateway, "metrics.pushgateway", "", "", "URL to pushgateway http://localhost:9091")
+
+	stringVar(&config.Release.Tag, "release.tag", "RELEASE_TAG", `^release-(\d{8}).*$`, "")
+	flag.Float64Var(&config.Release.DaysNotDelete, "release.daysNotDelete", defaultNotDeleteDays, "")
+	flag.IntVar(&config.Release.MinTags, "release.minTags", defaultMinNotDeleteTags, "")
+
+	stringVar(&config.System.Tag, "system.tag", "SYSTEM_TAG", `^(main|master)$`, "")
+
+	boolVar(&config.Snapshot.Enabled, "snapshots", "", false, "enable snapshot clearing")
+	stringVar(&config.Snapshot.Repository, "snapshot.repository", "SNAPSHOT_REPOSITORY", `^devops/docker/mysql-.+$`, "")
+	stringVar(&config.Snapshot.Tag, "snapshot.tag", "SNAPSHOT_TAG", `^(\d{8})-snap$`, "")
+	flag.Float64Var(&config.Snapshot.DaysNotDelete, "snapshot.daysNotDelete", defaultNotDeleteDays, "")
+	flag.IntVar(&config.Snapshot.MinTags, "snapshot.minTags", defaultMinNotDeleteTags, "")
+
+	flag.IntVar(&config.Branch.StaleDays, "branch.staleDays", defaultStaleBranchDays, "delete docker tag if last commit more than this days ago") //nolint:lll
+	stringVar(&config.Branch.Tag, "branch.tag", "", "", "regexp of branch tags, tags without branch that do not match it are unknown")            //nolint:lll
+	boolVar(&config.Branch.KeepOpenMergeRequests, "branch.keepOpenMergeRequests", "", false, "keep images of branches with open merge requests")  //nolint:lll
+
+	boolVar(&config.Unknown.Enabled, "unknown.enabled", "", false, "delete tags that match no classifier when image is older than unknown.daysNotDelete") //nolint:lll
+	flag.Float64Var(&config.Unknown.DaysNotDelete, "unknown.daysNotDelete", defaultUnknownDays, "")
+	flag.IntVar(&config.Unknown.MinTags, "unknown.minTags", defaultMinNotDeleteTags, "newest unknown tags of repository that are never deleted") //nolint:lll
+
+	boolVar(&config.Projects.Archived.Enabled, "projects.archived.enabled", "", false, "delete tags of archived projects except system and latest release tags") //nolint:lll
+	boolVar(&config.Projects.Missing.Enabled, "projects.missing.enabled", "", false, "delete tags of repositories without project after grace period")           //nolint:lll
+	flag.DurationVar(&config.Projects.Missing.GracePeriod, "projects.missing.grace-period", defaultMissingGracePeriod, "")
+	stringVar(&config.Projects.StateFile, "projects.state-file", "", "", "path to state file with repositories which project is not found") //nolint:lll
+
+	flag.IntVar(&config.Retry.MaxAttempts, "retry.max-attempts", defaultMaxAttempts, "attempts of request on 429, 5xx and network errors") //nolint:lll
+	flag.DurationVar(&config.Retry.InitialBackoff, "retry.initial-backoff", defaultInitialBackoff, "")
+	flag.DurationVar(&config.Retry.MaxBackoff, "retry.max-backoff", defaultMaxBackoff, "")
+	stringVar(&config.Retry.OnError, "retry.on-error", "", OnErrorSkip, "skip or abort, action when project request fails after all attempts") //nolint:lll
+
+	boolVar(&config.Environments.KeepDeployed, "environments.keepDeployed", "", false, "keep images deployed to available environments") //nolint:lll
+
+	boolVar(&config.Kubernetes.Enabled, "kubernetes.enabled", "", false, "keep images that are used in kubernetes clusters")
+	stringVar(&config.Kubernetes.Kubeconfig, "kubernetes.kubeconfig", "", "", "path to kubeconfig")
+	stringVar(&config.Kubernetes.Contexts, "kubernetes.contexts", "", "", "comma separated kubeconfig contexts")
+	stringVar(&config.Kubernetes.Registry, "kubernetes.registry", "", "", "use only images from this registry host")
+	boolVar(&config.Kubernetes.IgnoreErrors, "kubernetes.ignore-errors", "", false, "continue when cluster can not be read, its images are not protected") //nolint:lll
+
+	stringVar(&config.Tag.Arch, "tag.arch", "", "amd64,arm64", "tag suffix for arch")
+	boolVar(&config.Tag.Details, "tag.details", "", false, "add digest, media type, creation time and size of tags to plan")
+
+	boolVar(&config.CI.Check, "ci.check", "", false, "check if release tag is valid")
+	stringVar(&config.CI.Tag, "ci.tag", "CI_COMMIT_REF_NAME", "", "tag to check")
+	stringVar(&config.CI.CommitDate, "ci.commitDate", "CI_COMMIT_TIMESTAMP", "", "commit date to check")
+	flag.IntVar(&config.CI.ReleasesDeltaDays, "ci.releases-delta-days", defaultCheckReleseTagDelta, "number of days allowed to be between release tag and commit date") //nolint:lll
+}
+
+// Get current config.
+func Get() *Type {
+	return &config
+}
+
+// Load config file, flags and env variables take precedence over config file.
+func Load() error {
+	if len(*configFile) == 0 {
+		config.deprecated()
+
+		return config.Validate()
+	}
+
+	overrides := make(map[string]string)
+
+	// env variables
+	for name, env := range flagEnv {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+
+		// boolean env variables are true only with "true" value
+		if f, ok := flag.Lookup(name).Value.(interface{ IsBoolFlag() bool }); ok && f.IsBoolFlag() {
+			value = strconv.FormatBool(value == "true")
+		}
+
+		overrides[name] = value
+	}
+
+	// flags from command line
+	flag.Visit(func(f *flag.Flag) {
+		overrides[f.Name] = f.Value.String()
+	})
+
+	data, err := os.ReadFile(*configFile)
+	if err != nil {
+		return errors.Wrap(err, "can not read config file")
+	}
+
+	if err := config.parse(data); err != nil {
+		return errors.Wrap(err, *configFile)
+	}
+
+	for name, value := range overrides {
+		if err := flag.Set(name, value); err != nil {
+			return errors.Wrapf(err, "can not set flag %s", name)
+		}
+	}
+
+	config.deprecated()
+
+	return config.Validate()
+}
+
+// use values of deprecated fields.
+func (t *Type) deprecated() {
+	if len(t.S3.RegistryFolder) > 0 {
+		log.Warn("s3.registryFolder is deprecated, use storage.registryFolder")
+
+		t.Storage.RegistryFolder = t.S3.RegistryFolder
+	}
+}
+
+// parse yaml or json config, unknown fields are not allowed.
+func (t *Type) parse(data []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(t); err != nil {
+		return errors.Wrap(err, "can not parse config")
+	}
+
+	return nil
+}
+
+func validateRegexp(field, value string, groups int) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return errors.Wrap(err, field)
+	}
+
+	if re.NumSubexp() < groups {
+		return errors.Errorf("%s: %s must contain capture group with date YYYYMMDD", field, value)
+	}
+
+	return nil
+}
+
+// Validate config values.
+func (t *Type) Validate() error { //nolint:cyclop,funlen
+	validationErrors := make([]string, 0)
+
+	addError := func(err error) {
+		if err != nil {
+			validationErrors = append(validationErrors, err.Error())
+		}
+	}
+
+	switch t.Mode {
+	case ModeRun:
+	case ModePlan, ModeApply:
+		if len(t.Plan) == 0 {
+			addError(errors.Errorf("plan: must be set for %s mode", t.Mode))
+		}
+	case ModeExport:
+		if len(t.Inventory) == 0 {
+			addError(errors.New("inventory: must be set for export mode"))
+		}
+	default:
+		addError(errors.Errorf("mode: %s unknown mode", t.Mode))
+	}
+
+	if len(t.Inventory) > 0 && t.Mode != ModePlan && t.Mode != ModeExport {
+		addError(errors.Errorf("inventory: can not be used in %s mode", t.Mode))
+	}
+
+	switch t.Provider {
+	case "docker", "gitlab":
+	case "s3":
+		if len(t.S3.Bucket) == 0 {
+			addError(errors.New("s3.bucket: must be set for s3 provider"))
+		}
+	case "gcs":
+		if len(t.GCS.Bucket) == 0 {
+			addError(errors.New("gcs.bucket: must be set for gcs provider"))
+		}
+	case "azure":
+		if len(t.Azure.Container) == 0 {
+			addError(errors.New("azure.container: must be set for azure provider"))
+		}
+
+		if len(t.Azure.ConnectionString) == 0 && len(t.Azure.AccountName) == 0 && len(t.Azure.Endpoint) == 0 {
+			addError(errors.New("azure.accountName: must be set for azure provider without connection string"))
+		}
+	case "filesystem":
+		if len(t.Filesystem.Root) == 0 {
+			addError(errors.New("filesystem.root: must be set for filesystem provider"))
+		}
+	default:
+		addError(errors.Errorf("provider: %s unknown provider", t.Provider))
+	}
+
+	if t.IsStorageProvider() {
+		addError(t.Storage.validate())
+	}
+
+	addError(validateRegexp("registry.filter", t.Registry.Filter, 0))
+	addError(validateRegexp("registry.ignore", t.Registry.Ignore, 0))
+
+	if t.Registry.Workers <= 0 {
+		addError(errors.Errorf("registry.workers: must be greater than 0, got %d", t.Registry.Workers))
+	}
+
+	if t.Registry.RequestsPerSecond < 0 {
+		addError(errors.Errorf("registry.requestsPerSecond: must not be negative, got %f", t.Registry.RequestsPerSecond))
+	}
+
+	addError(validateRegexp("system.tag", t.System.Tag, 0))
+	addError(t.Release.validate("release"))
+
+	if t.Snapshot.Enabled {
+		addError(validateRegexp("snapshot.repository", t.Snapshot.Repository, 0))
+		addError(t.Snapshot.validate("snapshot"))
+	}
+
+	if t.Branch.StaleDays <= 0 {
+		addError(errors.Errorf("branch.staleDays: must be greater than 0, got %d", t.Branch.StaleDays))
+	}
+
+	addError(validateRegexp("branch.tag", t.Branch.Tag, 0))
+
+	if t.Unknown.Enabled {
+		if t.Unknown.DaysNotDelete < 0 {
+			addError(errors.Errorf("unknown.daysNotDelete: must not be negative, got %f", t.Unknown.DaysNotDelete))
+		}
+
+		if t.Unknown.MinTags < 0 {
+			addError(errors.Errorf("unknown.minTags: must not be negative, got %d", t.Unknown.MinTags))
+		}
+	}
+
+	addError(t.Retry.validate())
+
+	for _, err := range t.Projects.validate() {
+		addError(err)
+	}
+
+	ruleNames := make(map[string]bool)
+
+	for i, rule := range t.Rules {
+		prefix := fmt.Sprintf("rules[%d]", i)
+
+		addError(rule.validate(prefix))
+
+		if len(rule.Name) > 0 {
+			if ruleNames[rule.Name] || rule.Name == defaultPolicyName {
+				addError(errors.Errorf("%s.name: %s must be unique", prefix, rule.Name))
+			}
+
+			ruleNames[rule.Name] = true
+		}
+	}
+
+	for i, classifier := range t.Classifiers {
+		addError(classifier.validate(fmt.Sprintf("classifiers[%d]", i)))
+	}
+
+	if t.CI.ReleasesDeltaDays < 0 {
+		addError(errors.Errorf("ci.releasesDeltaDays: must not be negative, got %d", t.CI.ReleasesDeltaDays))
+	}
+
+	if len(validationErrors) > 0 {
+		return errors.Errorf("invalid config:\n%s", strings.Join(validationErrors, "\n"))
+	}
+
+	t.compile()
+
+	return nil
+}
+
+// compile patterns of validated config once, they are used for every repository and tag.
+func (t *Type) compile() {
+	t.releaseTagRegexp = regexp.MustCompile(t.Release.Tag)
+	t.systemTagRegexp = regexp.MustCompile(t.System.Tag)
+
+	if len(t.Branch.Tag) > 0 {
+		t.branchTagRegexp = regexp.MustCompile(t.Branch.Tag)
+	}
+
+	for i := range t.Rules {
+		t.Rules[i].compile()
+	}
+
+	for i := range t.Projects.Rewrites {
+		t.Projects.Rewrites[i].repositoryRegexp = regexp.MustCompile(t.Projects.Rewrites[i].Repository)
+	}
+}
+
+// Provider works with registry storage layout in object storage.
+func (t *Type) IsStorageProvider() bool {
+	return utils.StringInSlice(t.Provider, []string{"s3", "gcs", "azure", "filesystem"})
+}
+
+func (s *Storage) validate() error {
+	if len(s.RegistryFolder) == 0 {
+		return errors.New("storage.registryFolder: must be set")
+	}
+
+	if s.ListWorkers <= 0 {
+		return errors.Errorf("storage.listWorkers: must be greater than 0, got %d", s.ListWorkers)
+	}
+
+	if s.UploadsMaxAge < 0 {
+		return errors.Errorf("storage.uploadsMaxAge: must not be negative, got %s", s.UploadsMaxAge)
+	}
+
+	if s.GC.MinAge < 0 {
+		return errors.Errorf("storage.gc.minAge: must not be negative, got %s", s.GC.MinAge)
+	}
+
+	return nil
+}
+
+func (r *Retention) validate(prefix string) error {
+	if err := validateRegexp(prefix+".tag", r.Tag, 1); err != nil {
+		return err
+	}
+
+	if r.DaysNotDelete < 0 {
+		return errors.Errorf("%s.daysNotDelete: must not be negative, got %f", prefix, r.DaysNotDelete)
+	}
+
+	if r.MinTags < 0 {
+		return errors.Errorf("%s.minTags: must not be negative, got %d", prefix, r.MinTags)
+	}
+
+	return nil
+}
+
+func (r *Retry) validate() error {
+	if r.MaxAttempts <= 0 {
+		return errors.Errorf("retry.maxAttempts: must be greater than 0, got %d", r.MaxAttempts)
+	}
+
+	if r.InitialBackoff <= 0 || r.MaxBackoff < r.InitialBackoff {
+		return errors.Errorf("retry.initialBackoff: must be greater than 0 and not greater than maxBackoff, got %s and %s", r.InitialBackoff, r.MaxBackoff) //nolint:lll
+	}
+
+	switch r.OnError {
+	case OnErrorSkip, OnErrorAbort:
+	default:
+		return errors.Errorf("retry.onError: must be %s or %s, got %q", OnErrorSkip, OnErrorAbort, r.OnError)
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, "policy.yaml", `
+provider: s3
+s3:
+  bucket: registry
+  registryFolder: registry/repositories/
+storage:
+  gc:
+    enabled: true
+    minAge: 48h
+release:
+  daysNotDelete: 20
+  minTags: 1
+system:
+  tag: ^(main|master|develop)$
+`)
+
+	t.Setenv("SYSTEM_TAG", "^main$")
+
+	if err := flag.Set("config", path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := flag.Set("release.minTags", "5"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	result := config.Get()
+
+	if result.Provider != "s3" {
+		t.Fatalf("provider %s need s3", result.Provider)
+	}
+
+	if result.S3.Bucket != "registry" {
+		t.Fatalf("s3.bucket %s need registry", result.S3.Bucket)
+	}
+
+	if !result.Storage.GC.Enabled || result.Storage.GC.MinAge != 48*time.Hour {
+		t.Fatalf("storage.gc %+v is not correct", result.Storage.GC)
+	}
+
+	// deprecated field must be used
+	if result.Storage.RegistryFolder != "registry/repositories/" {
+		t.Fatalf("storage.registryFolder %s need registry/repositories/", result.Storage.RegistryFolder)
+	}
+
+	if result.Release.DaysNotDelete != 20 {
+		t.Fatalf("release.daysNotDelete %f need 20", result.Release.DaysNotDelete)
+	}
+
+	// flag must override config file
+	if result.Release.MinTags != 5 {
+		t.Fatalf("release.minTags %d need 5", result.Release.MinTags)
+	}
+
+	// env must override config file
+	if result.System.Tag != "^main$" {
+		t.Fatalf("system.tag %s need ^main$", result.System.Tag)
+	}
+
+	// default value must stay
+	if result.Release.Tag != `^release-(\d{8}).*$` {
+		t.Fatalf("release.tag %s is not default", result.Release.Tag)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := make(map[string]string)
+
+	tests["policy.yaml"] = "provider: docker\nunknown: true\n"
+	tests["policy.json"] = `{"provider": "docker", "release": {"tag": "^release-.*$"}}`
+	tests["provider.json"] = `{"provider": "fake"}`
+
+	for name, content := range tests {
+		if err := flag.Set("config", writeConfig(t, name, content)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := config.Load(); err == nil {
+			t.Fatalf("%s must return error", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := make(map[string]func(*config.Type))
+
+	tests["provider"] = func(c *config.Type) { c.Provider = "fake" }
+	tests["mode"] = func(c *config.Type) { c.Mode = "fake" }
+	tests["plan"] = func(c *config.Type) { c.Mode = config.ModeApply }
+	tests["inventory"] = func(c *config.Type) { c.Inventory = "inventory.json" }
+	tests["s3.bucket"] = func(c *config.Type) { c.Provider = "s3"; c.S3.Bucket = "" }
+	tests["filesystem.root"] = func(c *config.Type) { c.Provider = "filesystem" }
+	tests["gcs.bucket"] = func(c *config.Type) { c.Provider = "gcs" }
+	tests["azure.container"] = func(c *config.Type) { c.Provider = "azure"; c.Azure.AccountName = "registry" }
+	tests["azure.accountName"] = func(c *config.Type) { c.Provider = "azure"; c.Azure.Container = "registry" }
+	tests["storage.registryFolder"] = func(c *config.Type) { c.Provider = "gcs"; c.GCS.Bucket = "registry"; c.Storage.RegistryFolder = "" }
+	tests["storage.uploadsMaxAge"] = func(c *config.Type) { c.Provider = "s3"; c.S3.Bucket = "registry"; c.Storage.UploadsMaxAge = -1 }
+	tests["storage.gc.minAge"] = func(c *config.Type) { c.Provider = "s3"; c.S3.Bucket = "registry"; c.Storage.GC.MinAge = -1 }
+	tests["storage.listWorkers"] = func(c *config.Type) { c.Provider = "filesystem"; c.Filesystem.Root = "/"; c.Storage.ListWorkers = 0 }
+	tests["registry.workers"] = func(c *config.Type) { c.Registry.Workers = 0 }
+	tests["registry.requestsPerSecond"] = func(c *config.Type) { c.Registry.RequestsPerSecond = -1 }
+	tests["release.tag"] = func(c *config.Type) { c.Release.Tag = "^release-.*$" }
+	tests["system.tag"] = func(c *config.Type) { c.System.Tag = "^(main" }
+	tests["release.minTags"] = func(c *config.Type) { c.Release.MinTags = -1 }
+	tests["snapshot.daysNotDelete"] = func(c *config.Type) { c.Snapshot.Enabled = true; c.Snapshot.DaysNotDelete = -1 }
+	tests["branch.staleDays"] = func(c *config.Type) { c.Branch.StaleDays = 0 }
+	tests["branch.tag"] = func(c *config.Type) { c.Branch.Tag = "^(feature" }
+	tests["unknown.minTags"] = func(c *config.Type) { c.Unknown.Enabled = true; c.Unknown.MinTags = -1 }
+	tests["retry.maxAttempts"] = func(c *config.Type) { c.Retry.MaxAttempts = 0 }
+	tests["retry.initialBackoff"] = func(c *config.Type) { c.Retry.MaxBackoff = time.Millisecond }
+	tests["retry.onError"] = func(c *config.Type) { c.Retry.OnError = "fake" }
+	tests["projects.rewrites[0].repository"] = func(c *config.Type) { c.Projects.Rewrites = []config.Rewrite{{Repository: "^(mirror"}} }
+	tests["projects.repositories[0].project"] = func(c *config.Type) {
+		c.Projects.Repositories = []config.RepositoryProject{{Repository: "mirror/image"}}
+	}
+	tests["projects.repositories[1].repository"] = func(c *config.Type) {
+		c.Projects.Repositories = []config.RepositoryProject{
+			{Repository: "mirror/image", Project: "group/a"},
+			{Repository: "mirror/image", Project: "group/b"},
+		}
+	}
+	tests["projects.stateFile"] = func(c *config.Type) { c.Projects.Missing.Enabled = true }
+	tests["projects.missing.gracePeriod"] = func(c *config.Type) {
+		c.Projects.Missing = config.ProjectsMissing{Enabled: true, GracePeriod: -1}
+		c.Projects.StateFile = "state.json"
+	}
+	tests["rules[0]"] = func(c *config.Type) { c.Rules = []config.Rule{{Name: "empty"}} }
+	tests["rules[0].release.tag"] = func(c *config.Type) {
+		c.Rules = []config.Rule{{Repository: "^test$", Release: config.RuleRetention{Tag: ptr("^release$")}}}
+	}
+	tests["classifiers[0].action"] = func(c *config.Type) {
+		c.Classifiers = []config.Classifier{{Name: "hotfix", Tag: "^hotfix-.+$", TagType: "Hotfix", Action: "fake"}}
+	}
+	tests["rules[1].name"] = func(c *config.Type) {
+		c.Rules = []config.Rule{{Name: "test", Repository: "^test$"}, {Name: "test", Project: "^test$"}}
+	}
+
+	for field, modify := range tests {
+		test := validConfig()
+		modify(&test)
+
+		err := test.Validate()
+		if err == nil {
+			t.Fatalf("%s must return error", field)
+		}
+
+		if !strings.Contains(err.Error(), field+":") {
+			t.Fatalf("error %s must contain field %s", err.Error(), field)
+		}
+	}
+
+	test := validConfig()
+
+	if err := test.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetPolicy(t *testing.T) {
+	t.Parallel()
+
+	test := validConfig()
+	test.System.Tag = "^main$"
+	test.Release.DaysNotDelete = 10
+	test.Release.MinTags = 3
+	test.Rules = []config.Rule{
+		{
+			Name:       "weekly",
+			Repository: "^group/service/.+$",
+			Release: config.RuleRetention{
+				DaysNotDelete: ptr(60.0),
+			},
+		},
+		{
+			Name:    "tooling",
+			Project: "^tooling/.+$",
+			Release: config.RuleRetention{
+				DaysNotDelete: ptr(3.0),
+				MinTags:       ptr(1),
+			},
+			System: config.RuleSystem{
+				Tag: ptr("^(main|develop)$"),
+			},
+			Branch: config.RuleBranch{
+				StaleDays: ptr(7),
+			},
+		},
+		{
+			Name:    "duplicate",
+			Project: "^tooling/.+$",
+			Release: config.RuleRetention{
+				DaysNotDelete: ptr(100.0),
+			},
+		},
+	}
+
+	if err := test.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := test.GetPolicy("group/service", "group/service/image")
+	if policy.Name != "weekly" || policy.ReleaseDaysNotDelete != 60 || policy.ReleaseMinTags != 3 {
+		t.Fatalf("policy %+v is not correct", policy)
+	}
+
+	// first matched rule wins
+	policy = test.GetPolicy("tooling/cli", "tooling/cli/image")
+	if policy.Name != "tooling" || policy.ReleaseDaysNotDelete != 3 || policy.ReleaseMinTags != 1 || policy.BranchStaleDays != 7 { //nolint:lll
+		t.Fatalf("policy %+v is not correct", policy)
+	}
+
+	if !policy.SystemTag.MatchString("develop") {
+		t.Fatal("develop must be system tag")
+	}
+
+	policy = test.GetPolicy("group/other", "group/other/image")
+	if policy.Name != "default" || policy.ReleaseDaysNotDelete != 10 || policy.BranchStaleDays != 30 {
+		t.Fatalf("policy %+v is not correct", policy)
+	}
+
+	// patterns are compiled once on validation
+	if policy.ReleaseTag != test.GetPolicy("group/another", "group/another/image").ReleaseTag {
+		t.Fatal("release tag must be compiled once")
+	}
+}
+
+func TestProjects(t *testing.T) {
+	t.Parallel()
+
+	test := validConfig()
+	test.Projects = config.Projects{
+		Rewrites: []config.Rewrite{
+			{Repository: "^mirror/(.*)$", Replacement: "$1"},
+			{Repository: "^mirror/.*$", Replacement: "unused"},
+			{Repository: "^monorepo/([^/]+)/.+$", Replacement: "group/$1/image"},
+		},
+		Repositories: []config.RepositoryProject{
+			{Repository: "acr/service", Project: "group/service", ProjectID: 10},
+		},
+	}
+
+	if err := test.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"mirror/group/project/image": "group/project/image",
+		"monorepo/service/cli":       "group/service/image",
+		"group/project/image":        "group/project/image",
+	}
+
+	for in, out := range tests {
+		if result := test.RewriteRepository(in); result != out {
+			t.Fatalf("%s result %s need %s", in, result, out)
+		}
+	}
+
+	project := test.GetRepositoryProject("acr/service")
+	if project == nil || project.Project != "group/service" || project.ProjectID != 10 {
+		t.Fatalf("project %+v is not correct", project)
+	}
+
+	if project := test.GetRepositoryProject("acr/service/image"); project != nil {
+		t.Fatalf("project %+v must not be found", project)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func validConfig() config.Type {
+	return config.Type{
+		Mode:     config.ModeRun,
+		Provider: "docker",
+		Registry: config.Registry{
+			Workers: 1,
+		},
+		Retry: config.Retry{
+			MaxAttempts:    1,
+			InitialBackoff: time.Second,
+			MaxBackoff:     time.Second,
+			OnError:        config.OnErrorSkip,
+		},
+		Release: config.Retention{
+			Tag: `^release-(\d{8}).*$`,
+		},
+		Snapshot: config.Snapshot{
+			Retention: config.Retention{
+				Tag: `^(\d{8})-snap$`,
+			},
+		},
+		Branch: config.Branch{
+			StaleDays: 30,
+		},
+		Storage: config.Storage{
+			RegistryFolder: "docker/registry/v2/repositories/",
+			ListWorkers:    1,
+		},
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Rewrite changes docker repository path before gitlab project is resolved from it.
+type Rewrite struct {
+	// docker repository regexp
+	Repository string `yaml:"repository"`
+	// replacement with capture groups, for example $1
+	Replacement string `yaml:"replacement"`
+
+	// pattern is compiled after validation
+	repositoryRegexp *regexp.Regexp
+}
+
+// RepositoryProject is explicit gitlab project of docker repository.
+type RepositoryProject struct {
+	Repository string `yaml:"repository"`
+	// gitlab project path
+	Project string `yaml:"project"`
+	// gitlab project ID, project is not requested by path if set
+	ProjectID int `yaml:"projectID"`
+}
+
+type ProjectsArchived struct {
+	// delete tags of archived projects except system and latest release tags
+	Enabled bool `yaml:"enabled"`
+}
+
+type ProjectsMissing struct {
+	// delete tags of repositories which project is not found
+	Enabled bool `yaml:"enabled"`
+	// time from first run when project was not found
+	GracePeriod time.Duration `yaml:"gracePeriod"`
+}
+
+// Projects maps docker repositories that do not follow gitlab registry paths to gitlab projects,
+// archived and missing projects are cleaned by own policies.
+type Projects struct {
+	// first matched rewrite is applied
+	Rewrites     []Rewrite           `yaml:"rewrites"`
+	Repositories []RepositoryProject `yaml:"repositories"`
+	Archived     ProjectsArchived    `yaml:"archived"`
+	Missing      ProjectsMissing     `yaml:"missing"`
+	// state of missing projects between runs
+	StateFile string `yaml:"stateFile"`
+}
+
+func (p *Projects) validate() []error {
+	result := make([]error, 0)
+
+	for i, rewrite := range p.Rewrites {
+		prefix := fmt.Sprintf("projects.rewrites[%d]", i)
+
+		if len(rewrite.Repository) == 0 {
+			result = append(result, errors.Errorf("%s.repository: must be set", prefix))
+
+			continue
+		}
+
+		if err := validateRegexp(prefix+".repository", rewrite.Repository, 0); err != nil {
+			result = append(result, err)
+		}
+	}
+
+	repositories := make(map[string]bool)
+
+	for i, repository := range p.Repositories {
+		prefix := fmt.Sprintf("projects.repositories[%d]", i)
+
+		if len(repository.Repository) == 0 {
+			result = append(result, errors.Errorf("%s.repository: must be set", prefix))
+		}
+
+		if repositories[repository.Repository] {
+			result = append(result, errors.Errorf("%s.repository: %s must be unique", prefix, repository.Repository))
+		}
+
+		repositories[repository.Repository] = true
+
+		if len(repository.Project) == 0 {
+			result = append(result, errors.Errorf("%s.project: must be set", prefix))
+		}
+
+		if repository.ProjectID < 0 {
+			result = append(result, errors.Errorf("%s.projectID: must not be negative, got %d", prefix, repository.ProjectID))
+		}
+	}
+
+	if p.Missing.Enabled {
+		if len(p.StateFile) == 0 {
+			result = append(result, errors.New("projects.stateFile: must be set for projects.missing.enabled"))
+		}
+
+		if p.Missing.GracePeriod < 0 {
+			result = append(result, errors.Errorf("projects.missing.gracePeriod: must not be negative, got %s", p.Missing.GracePeriod))
+		}
+	}
+
+	return result
+}
+
+// GetRepositoryProject returns explicit gitlab project of docker repository or nil.
+func (t *Type) GetRepositoryProject(repository string) *RepositoryProject {
+	for _, item := range t.Projects.Repositories {
+		if item.Repository == repository {
+			return &item
+		}
+	}
+
+	return nil
+}
+
+// RewriteRepository returns docker repository path rewritten by first matched rewrite.
+func (t *Type) RewriteRepository(repository string) string {
+	for _, rewrite := range t.Projects.Rewrites {
+		if rewrite.repositoryRegexp.MatchString(repository) {
+			return rewrite.repositoryRegexp.ReplaceAllString(repository, rewrite.Replacement)
+		}
+	}
+
+	return repository
+}
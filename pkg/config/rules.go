@@ -0,0 +1,203 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+const defaultPolicyName = "default"
+
+type RuleRetention struct {
+	Tag           *string  `yaml:"tag"`
+	DaysNotDelete *float64 `yaml:"daysNotDelete"`
+	MinTags       *int     `yaml:"minTags"`
+}
+
+type RuleSystem struct {
+	Tag *string `yaml:"tag"`
+}
+
+type RuleBranch struct {
+	StaleDays *int    `yaml:"staleDays"`
+	Tag       *string `yaml:"tag"`
+}
+
+// Rule overrides retention values for matched repositories,
+// not set values will be taken from global config.
+type Rule struct {
+	Name string `yaml:"name"`
+	// docker repository regexp
+	Repository string `yaml:"repository"`
+	// gitlab project path regexp
+	Project string        `yaml:"project"`
+	Release RuleRetention `yaml:"release"`
+	System  RuleSystem    `yaml:"system"`
+	Branch  RuleBranch    `yaml:"branch"`
+
+	// patterns are compiled after validation
+	repositoryRegexp *regexp.Regexp
+	projectRegexp    *regexp.Regexp
+	releaseTagRegexp *regexp.Regexp
+	systemTagRegexp  *regexp.Regexp
+	branchTagRegexp  *regexp.Regexp
+}
+
+// Policy is resolved retention values for repository.
+type Policy struct {
+	Name                 string
+	ReleaseTag           *regexp.Regexp
+	ReleaseDaysNotDelete float64
+	ReleaseMinTags       int
+	SystemTag            *regexp.Regexp
+	BranchStaleDays      int
+	// nil if all tags are branch tags
+	BranchTag *regexp.Regexp
+}
+
+func (r *Rule) match(project, repository string) bool {
+	if r.repositoryRegexp != nil && !r.repositoryRegexp.MatchString(repository) {
+		return false
+	}
+
+	if r.projectRegexp != nil && !r.projectRegexp.MatchString(project) {
+		return false
+	}
+
+	return true
+}
+
+// compile patterns of validated rule.
+func (r *Rule) compile() {
+	if len(r.Repository) > 0 {
+		r.repositoryRegexp = regexp.MustCompile(r.Repository)
+	}
+
+	if len(r.Project) > 0 {
+		r.projectRegexp = regexp.MustCompile(r.Project)
+	}
+
+	if r.Release.Tag != nil {
+		r.releaseTagRegexp = regexp.MustCompile(*r.Release.Tag)
+	}
+
+	if r.System.Tag != nil {
+		r.systemTagRegexp = regexp.MustCompile(*r.System.Tag)
+	}
+
+	if r.Branch.Tag != nil && len(*r.Branch.Tag) > 0 {
+		r.branchTagRegexp = regexp.MustCompile(*r.Branch.Tag)
+	}
+}
+
+func (r *Rule) validate(prefix string) error { //nolint:cyclop
+	if len(r.Repository) == 0 && len(r.Project) == 0 {
+		return errors.Errorf("%s: repository or project must be set", prefix)
+	}
+
+	if err := validateRegexp(prefix+".repository", r.Repository, 0); err != nil {
+		return err
+	}
+
+	if err := validateRegexp(prefix+".project", r.Project, 0); err != nil {
+		return err
+	}
+
+	if r.Release.Tag != nil {
+		if err := validateRegexp(prefix+".release.tag", *r.Release.Tag, 1); err != nil {
+			return err
+		}
+	}
+
+	if r.Release.DaysNotDelete != nil && *r.Release.DaysNotDelete < 0 {
+		return errors.Errorf("%s.release.daysNotDelete: must not be negative, got %f", prefix, *r.Release.DaysNotDelete)
+	}
+
+	if r.Release.MinTags != nil && *r.Release.MinTags < 0 {
+		return errors.Errorf("%s.release.minTags: must not be negative, got %d", prefix, *r.Release.MinTags)
+	}
+
+	if r.System.Tag != nil {
+		if err := validateRegexp(prefix+".system.tag", *r.System.Tag, 0); err != nil {
+			return err
+		}
+	}
+
+	if r.Branch.StaleDays != nil && *r.Branch.StaleDays <= 0 {
+		return errors.Errorf("%s.branch.staleDays: must be greater than 0, got %d", prefix, *r.Branch.StaleDays)
+	}
+
+	if r.Branch.Tag != nil {
+		if err := validateRegexp(prefix+".branch.tag", *r.Branch.Tag, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetPolicy returns retention values for docker repository of gitlab project, first matched rule wins.
+func (t *Type) GetPolicy(project, repository string) *Policy {
+	policy := Policy{
+		Name:                 defaultPolicyName,
+		ReleaseTag:           t.releaseTagRegexp,
+		ReleaseDaysNotDelete: t.Release.DaysNotDelete,
+		ReleaseMinTags:       t.Release.MinTags,
+		SystemTag:            t.systemTagRegexp,
+		BranchStaleDays:      t.Branch.StaleDays,
+		BranchTag:            t.branchTagRegexp,
+	}
+
+	for i, rule := range t.Rules {
+		if !rule.match(project, repository) {
+			continue
+		}
+
+		policy.Name = rule.Name
+		if len(policy.Name) == 0 {
+			policy.Name = fmt.Sprintf("rules[%d]", i)
+		}
+
+		if rule.releaseTagRegexp != nil {
+			policy.ReleaseTag = rule.releaseTagRegexp
+		}
+
+		if rule.Release.DaysNotDelete != nil {
+			policy.ReleaseDaysNotDelete = *rule.Release.DaysNotDelete
+		}
+
+		if rule.Release.MinTags != nil {
+			policy.ReleaseMinTags = *rule.Release.MinTags
+		}
+
+		if rule.systemTagRegexp != nil {
+			policy.SystemTag = rule.systemTagRegexp
+		}
+
+		if rule.Branch.StaleDays != nil {
+			policy.BranchStaleDays = *rule.Branch.StaleDays
+		}
+
+		// empty tag of rule makes all tags branch tags
+		if rule.Branch.Tag != nil {
+			policy.BranchTag = rule.branchTagRegexp
+		}
+
+		break
+	}
+
+	return &policy
+}
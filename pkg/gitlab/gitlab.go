@@ -14,36 +14,39 @@ package gitlab
 
 import (
 	"context"
-	"flag"
-	"os"
-	"time"
+	"net/http"
 
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/ratelimit"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/retry"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/utils"
 	"github.com/pkg/errors"
 	gitlab "gitlab.com/gitlab-org/api/client-go"
 )
 
-var (
-	gitlabToken = flag.String("gitlab.token", os.Getenv("GITLAB_TOKEN"), "")
-	gitlabURL   = flag.String("gitlab.url", os.Getenv("GITLAB_URL"), "")
-)
-
 const (
 	// max list size for gitlab api.
 	gilabAPIMaxListSize = 100
-	// hours in day.
-	hoursInDay = 24
-	// delete docker tag if last commit more than 30 days ago.
-	staleBranchDays = 30
+	// length of CI_COMMIT_SHORT_SHA.
+	shortSHALength = 8
 )
 
 var git *gitlab.Client
 
+// ErrNotFound is returned when project is not found.
+var ErrNotFound = gitlab.ErrNotFound
+
 // Gitlab create new client.
 func Init() error {
 	var err error
 
-	git, err = gitlab.NewClient(*gitlabToken, gitlab.WithBaseURL(*gitlabURL))
+	git, err = gitlab.NewClient(config.Get().Gitlab.Token,
+		gitlab.WithBaseURL(config.Get().Gitlab.URL),
+		// requests are retried by shared retry layer
+		gitlab.WithoutRetries(),
+		gitlab.WithHTTPClient(retry.Client(ratelimit.Client(&http.Client{}), "gitlab")),
+	)
 	if err != nil {
 		return errors.Wrap(err, "can not connect to gitlab")
 	}
@@ -51,28 +54,52 @@ func Init() error {
 	return nil
 }
 
-// Return project ID on docker repo.
-func GetProjectID(ctx context.Context, dockerRepo string) (int, error) {
+// Return project of project path.
+func GetProject(ctx context.Context, path string) (*types.GitlabProject, error) {
 	gitlabProject, _, err := git.Projects.GetProject(
-		dockerRepo,
+		path,
 		&gitlab.GetProjectOptions{},
 		gitlab.WithContext(ctx))
 	if err != nil {
-		return 0, errors.Wrap(err, dockerRepo)
+		return nil, errors.Wrap(err, path)
 	}
 
-	return gitlabProject.ID, nil
+	return &types.GitlabProject{
+		ID:       gitlabProject.ID,
+		Path:     gitlabProject.PathWithNamespace,
+		Archived: gitlabProject.Archived,
+	}, nil
 }
 
-type GetProjectBranchesResult struct {
-	Staled             bool
-	StaledDays         int
-	OriginalBranchName string
+// Return true if token can read all projects of group, gitlab returns not found for projects
+// that token can not read, so only not found projects of such groups are missing.
+func CanReadGroupProjects(ctx context.Context, group string) (bool, error) {
+	user, _, err := git.Users.CurrentUser(gitlab.WithContext(ctx))
+	if err != nil {
+		return false, errors.Wrap(err, "can not get current user")
+	}
+
+	// administrator reads all projects
+	if user.IsAdmin {
+		return true, nil
+	}
+
+	member, _, err := git.GroupMembers.GetInheritedGroupMember(group, user.ID, gitlab.WithContext(ctx))
+	if err != nil {
+		// group is not found or token is not member of group
+		if errors.Is(err, gitlab.ErrNotFound) {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, group)
+	}
+
+	return member.AccessLevel >= gitlab.ReporterPermissions, nil
 }
 
-// Return all gitlab branches slugnames with bool stage flag.
-func GetProjectBranches(ctx context.Context, projectID int) (map[string]*GetProjectBranchesResult, error) {
-	result := make(map[string]*GetProjectBranchesResult)
+// Return all gitlab branches slugnames with last commit date.
+func GetProjectBranches(ctx context.Context, projectID int) (map[string]*types.Branch, error) {
+	result := make(map[string]*types.Branch)
 
 	currentPage := 0
 
@@ -102,21 +129,404 @@ func GetProjectBranches(ctx context.Context, projectID int) (map[string]*GetProj
 		}
 
 		for _, gitBranch := range gitBranches {
-			lastCommitHoursAgo := time.Since(*gitBranch.Commit.CommittedDate).Hours()
 			branchSlug := utils.GitlabSluglify(gitBranch.Name)
 
-			item := GetProjectBranchesResult{
-				StaledDays:         staleBranchDays,
-				OriginalBranchName: gitBranch.Name,
+			result[branchSlug] = &types.Branch{
+				Name:           gitBranch.Name,
+				LastCommitDate: *gitBranch.Commit.CommittedDate,
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Return open merge requests of project by source branch slugname,
+// merge requests from forks are listed in target project.
+func GetProjectOpenMergeRequests(ctx context.Context, projectID int) (map[string]*types.MergeRequest, error) {
+	result := make(map[string]*types.MergeRequest)
+
+	currentPage := 0
+
+	for {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "context error")
+		}
+
+		currentPage++
+
+		mergeRequests, _, err := git.MergeRequests.ListProjectMergeRequests(
+			projectID,
+			&gitlab.ListProjectMergeRequestsOptions{
+				ListOptions: gitlab.ListOptions{
+					Page:    currentPage,
+					PerPage: gilabAPIMaxListSize,
+				},
+				State: gitlab.Ptr("opened"),
+			},
+			gitlab.WithContext(ctx),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not list merge requests")
+		}
+
+		if len(mergeRequests) == 0 {
+			break
+		}
+
+		for _, mergeRequest := range mergeRequests {
+			branchSlug := utils.GitlabSluglify(mergeRequest.SourceBranch)
+
+			result[branchSlug] = &types.MergeRequest{
+				IID:          mergeRequest.IID,
+				SourceBranch: mergeRequest.SourceBranch,
+				Fork:         mergeRequest.SourceProjectID != mergeRequest.TargetProjectID,
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Return last successful deployments of available environments by ref slugname,
+// commit sha and short commit sha.
+func GetProjectDeployments(ctx context.Context, projectID int) (map[string][]*types.Deployment, error) { //nolint:funlen
+	result := make(map[string][]*types.Deployment)
+
+	currentPage := 0
+
+	for {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "context error")
+		}
+
+		currentPage++
+
+		environments, _, err := git.Environments.ListEnvironments(
+			projectID,
+			&gitlab.ListEnvironmentsOptions{
+				ListOptions: gitlab.ListOptions{
+					Page:    currentPage,
+					PerPage: gilabAPIMaxListSize,
+				},
+				States: gitlab.Ptr("available"),
+			},
+			gitlab.WithContext(ctx),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not list environments")
+		}
+
+		if len(environments) == 0 {
+			break
+		}
+
+		for _, environment := range environments {
+			deployments, _, err := git.Deployments.ListProjectDeployments(
+				projectID,
+				&gitlab.ListProjectDeploymentsOptions{
+					ListOptions: gitlab.ListOptions{
+						PerPage: 1,
+					},
+					Environment: gitlab.Ptr(environment.Name),
+					Status:      gitlab.Ptr("success"),
+					OrderBy:     gitlab.Ptr("id"),
+					Sort:        gitlab.Ptr("desc"),
+				},
+				gitlab.WithContext(ctx),
+			)
+			if err != nil {
+				return nil, errors.Wrapf(err, "can not list deployments of %s", environment.Name)
+			}
+
+			if len(deployments) == 0 {
+				continue
+			}
+
+			deployment := &types.Deployment{
+				Environment: environment.Name,
+				Ref:         deployments[0].Ref,
+				SHA:         deployments[0].SHA,
+			}
+
+			for _, key := range DeploymentKeys(deployment) {
+				result[key] = append(result[key], deployment)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// DeploymentKeys returns ref slugname, commit sha and short commit sha of deployment.
+func DeploymentKeys(deployment *types.Deployment) []string {
+	keys := []string{utils.GitlabSluglify(deployment.Ref)}
+
+	if len(deployment.SHA) >= shortSHALength {
+		keys = append(keys, deployment.SHA, deployment.SHA[:shortSHALength])
+	}
+
+	return keys
+}
+
+// Source of gitlab project data for tag classification.
+type Source interface {
+	// Return project of project path
+	GetProject(ctx context.Context, path string) (*types.GitlabProject, error)
+	// Return true if token can read all projects of group
+	CanReadGroupProjects(ctx context.Context, group string) (bool, error)
+	// Return branches by slugname
+	GetProjectBranches(ctx context.Context, projectID int) (map[string]*types.Branch, error)
+	// Return open merge requests by source branch slugname
+	GetProjectOpenMergeRequests(ctx context.Context, projectID int) (map[string]*types.MergeRequest, error)
+	// Return last successful deployments by ref slugname, commit sha and short commit sha
+	GetProjectDeployments(ctx context.Context, projectID int) (map[string][]*types.Deployment, error)
+}
+
+// API is source of project data from gitlab, client must be initialized before.
+type API struct{}
+
+func (API) GetProject(ctx context.Context, path string) (*types.GitlabProject, error) {
+	return GetProject(ctx, path)
+}
+
+func (API) CanReadGroupProjects(ctx context.Context, group string) (bool, error) {
+	return CanReadGroupProjects(ctx, group)
+}
+
+func (API) GetProjectBranches(ctx context.Context, projectID int) (map[string]*types.Branch, error) {
+	return GetProjectBranches(ctx, projectID)
+}
+
+func (API) GetProjectOpenMergeRequests(ctx context.Context, projectID int) (map[string]*types.MergeRequest, error) {
+	return GetProjectOpenMergeRequests(ctx, projectID)
+}
+
+func (API) GetProjectDeployments(ctx context.Context, projectID int) (map[string][]*types.Deployment, error) {
+	return GetProjectDeployments(ctx, projectID)
+}
+
+// Registry repository of gitlab project.
+type RegistryRepository struct {
+	ID          int
+	Path        string
+	ProjectID   int
+	ProjectPath string
+}
+
+// Return registry repositories of groups or of all projects where token is member if groups are empty.
+func GetRegistryRepositories(ctx context.Context, groups []string) ([]*RegistryRepository, error) {
+	if len(groups) == 0 {
+		return getProjectsRegistryRepositories(ctx)
+	}
+
+	result := make([]*RegistryRepository, 0)
+	projectPaths := make(map[int]string)
+
+	for _, group := range groups {
+		currentPage := 0
+
+		for {
+			if ctx.Err() != nil {
+				return nil, errors.Wrap(ctx.Err(), "context error")
+			}
+
+			currentPage++
+
+			repositories, _, err := git.ContainerRegistry.ListGroupRegistryRepositories(
+				group,
+				&gitlab.ListRegistryRepositoriesOptions{
+					ListOptions: gitlab.ListOptions{
+						Page:    currentPage,
+						PerPage: gilabAPIMaxListSize,
+					},
+				},
+				gitlab.WithContext(ctx),
+			)
+			if err != nil {
+				return nil, errors.Wrapf(err, "can not list registry repositories of group %s", group)
 			}
 
-			if lastCommitHoursAgo > hoursInDay*staleBranchDays {
-				item.Staled = true
+			if len(repositories) == 0 {
+				break
 			}
 
-			result[branchSlug] = &item
+			for _, repository := range repositories {
+				// group api returns only project id
+				if _, ok := projectPaths[repository.ProjectID]; !ok {
+					project, _, err := git.Projects.GetProject(repository.ProjectID, &gitlab.GetProjectOptions{}, gitlab.WithContext(ctx))
+					if err != nil {
+						return nil, errors.Wrapf(err, "can not get project %d", repository.ProjectID)
+					}
+
+					projectPaths[repository.ProjectID] = project.PathWithNamespace
+				}
+
+				result = append(result, &RegistryRepository{
+					ID:          repository.ID,
+					Path:        repository.Path,
+					ProjectID:   repository.ProjectID,
+					ProjectPath: projectPaths[repository.ProjectID],
+				})
+			}
 		}
 	}
 
 	return result, nil
 }
+
+func getProjectsRegistryRepositories(ctx context.Context) ([]*RegistryRepository, error) {
+	result := make([]*RegistryRepository, 0)
+
+	currentPage := 0
+
+	for {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "context error")
+		}
+
+		currentPage++
+
+		projects, _, err := git.Projects.ListProjects(
+			&gitlab.ListProjectsOptions{
+				ListOptions: gitlab.ListOptions{
+					Page:    currentPage,
+					PerPage: gilabAPIMaxListSize,
+				},
+				Membership: gitlab.Ptr(true),
+				Simple:     gitlab.Ptr(true),
+			},
+			gitlab.WithContext(ctx),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not list projects")
+		}
+
+		if len(projects) == 0 {
+			break
+		}
+
+		for _, project := range projects {
+			repositories, err := getProjectRegistryRepositories(ctx, project)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, repositories...)
+		}
+	}
+
+	return result, nil
+}
+
+// Return all registry repositories of project.
+func getProjectRegistryRepositories(ctx context.Context, project *gitlab.Project) ([]*RegistryRepository, error) {
+	result := make([]*RegistryRepository, 0)
+
+	currentPage := 0
+
+	for {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "context error")
+		}
+
+		currentPage++
+
+		repositories, _, err := git.ContainerRegistry.ListProjectRegistryRepositories(
+			project.ID,
+			&gitlab.ListRegistryRepositoriesOptions{
+				ListOptions: gitlab.ListOptions{
+					Page:    currentPage,
+					PerPage: gilabAPIMaxListSize,
+				},
+			},
+			gitlab.WithContext(ctx),
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can not list registry repositories of %s", project.PathWithNamespace)
+		}
+
+		if len(repositories) == 0 {
+			break
+		}
+
+		for _, repository := range repositories {
+			result = append(result, &RegistryRepository{
+				ID:          repository.ID,
+				Path:        repository.Path,
+				ProjectID:   project.ID,
+				ProjectPath: project.PathWithNamespace,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// Return all tags of registry repository.
+func GetRegistryRepositoryTags(ctx context.Context, projectID, repositoryID int) ([]string, error) {
+	result := make([]string, 0)
+
+	currentPage := 0
+
+	for {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "context error")
+		}
+
+		currentPage++
+
+		tags, _, err := git.ContainerRegistry.ListRegistryRepositoryTags(
+			projectID,
+			repositoryID,
+			&gitlab.ListRegistryRepositoryTagsOptions{
+				Page:    currentPage,
+				PerPage: gilabAPIMaxListSize,
+			},
+			gitlab.WithContext(ctx),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not list registry repository tags")
+		}
+
+		if len(tags) == 0 {
+			break
+		}
+
+		for _, tag := range tags {
+			result = append(result, tag.Name)
+		}
+	}
+
+	return result, nil
+}
+
+// Return manifest digest of registry repository tag.
+func GetRegistryRepositoryTagDigest(ctx context.Context, projectID, repositoryID int, tag string) (string, error) {
+	detail, _, err := git.ContainerRegistry.GetRegistryRepositoryTagDetail(
+		projectID,
+		repositoryID,
+		tag,
+		gitlab.WithContext(ctx),
+	)
+	if err != nil {
+		return "", errors.Wrapf(err, "can not get tag %s", tag)
+	}
+
+	return detail.Digest, nil
+}
+
+// Delete registry repository tag, other tags of same manifest are not deleted.
+func DeleteRegistryRepositoryTag(ctx context.Context, projectID, repositoryID int, tag string) error {
+	_, err := git.ContainerRegistry.DeleteRegistryRepositoryTag(
+		projectID,
+		repositoryID,
+		tag,
+		gitlab.WithContext(ctx),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "can not delete tag %s", tag)
+	}
+
+	return nil
+}
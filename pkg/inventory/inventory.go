@@ -0,0 +1,306 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// current inventory file format version.
+const Version = 1
+
+const (
+	kindHeader     = "header"
+	kindRepository = "repository"
+	kindProject    = "project"
+	kindGroup      = "group"
+)
+
+type Header struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Provider  string    `json:"provider"`
+	// tags have manifest details
+	Details bool `json:"details,omitempty"`
+	// platform manifests of indexes are exported
+	Index bool `json:"index,omitempty"`
+	// provider deletes tag by deleting its manifest
+	Manifests bool `json:"manifests,omitempty"`
+}
+
+type Details struct {
+	MediaType string     `json:"mediaType,omitempty"`
+	Created   *time.Time `json:"created,omitempty"`
+	Size      int64      `json:"size,omitempty"`
+}
+
+type Tag struct {
+	Tag string `json:"tag"`
+	// empty when digest can not be read
+	Digest  string   `json:"digest,omitempty"`
+	Details *Details `json:"details,omitempty"`
+}
+
+type Repository struct {
+	Repository string `json:"repository"`
+	// gitlab project path of providers that know it
+	Project string `json:"project,omitempty"`
+	// error of listing tags, repository is skipped on plan
+	Error string `json:"error,omitempty"`
+	Tags  []*Tag `json:"tags"`
+	// platform manifests of indexes by index digest
+	Children map[string][]string `json:"children,omitempty"`
+}
+
+type Project struct {
+	Path string `json:"path"`
+	ID   int    `json:"id,omitempty"`
+	// project is not found in gitlab
+	NotFound bool `json:"notFound,omitempty"`
+	// current path of renamed or transferred project
+	PathWithNamespace string `json:"pathWithNamespace,omitempty"`
+	Archived          bool   `json:"archived,omitempty"`
+	// error of reading project, project is skipped on plan
+	Error             string                `json:"error,omitempty"`
+	Branches          []*types.Branch       `json:"branches,omitempty"`
+	OpenMergeRequests []*types.MergeRequest `json:"openMergeRequests,omitempty"`
+	Deployments       []*types.Deployment   `json:"deployments,omitempty"`
+}
+
+// Group is root group of repositories which projects are not found.
+type Group struct {
+	Path string `json:"path"`
+	// token can read all projects of group
+	ReadProjects bool `json:"readProjects,omitempty"`
+	// error of checking access to group, projects of group are not missing on plan
+	Error string `json:"error,omitempty"`
+}
+
+// Inventory is snapshot of registry and gitlab projects that is used to create plan without network.
+type Inventory struct {
+	Header
+	Repositories []*Repository `json:"repositories"`
+	Projects     []*Project    `json:"projects"`
+	Groups       []*Group      `json:"groups,omitempty"`
+
+	repositories map[string]*Repository
+	projects     map[string]*Project
+	projectIDs   map[int]*Project
+	groups       map[string]*Group
+}
+
+// line of ndjson file.
+type record struct {
+	Kind       string      `json:"kind"`
+	Header     *Header     `json:"header,omitempty"`
+	Repository *Repository `json:"repository,omitempty"`
+	Project    *Project    `json:"project,omitempty"`
+	Group      *Group      `json:"group,omitempty"`
+}
+
+func New(provider string) *Inventory {
+	return &Inventory{
+		Header: Header{
+			Version:   Version,
+			CreatedAt: time.Now().UTC(),
+			Provider:  provider,
+		},
+		Repositories: make([]*Repository, 0),
+		Projects:     make([]*Project, 0),
+	}
+}
+
+// Sort repositories, tags and projects to make inventory comparable.
+func (i *Inventory) Sort() {
+	sort.SliceStable(i.Repositories, func(a, b int) bool {
+		return i.Repositories[a].Repository < i.Repositories[b].Repository
+	})
+
+	for _, repository := range i.Repositories {
+		sort.SliceStable(repository.Tags, func(a, b int) bool {
+			return repository.Tags[a].Tag < repository.Tags[b].Tag
+		})
+	}
+
+	sort.SliceStable(i.Projects, func(a, b int) bool {
+		return i.Projects[a].Path < i.Projects[b].Path
+	})
+
+	sort.SliceStable(i.Groups, func(a, b int) bool {
+		return i.Groups[a].Path < i.Groups[b].Path
+	})
+}
+
+func isNDJSON(path string) bool {
+	return strings.HasSuffix(path, ".ndjson")
+}
+
+// Save inventory to json file or to ndjson file with one repository or project per line.
+func (i *Inventory) Save(path string) error {
+	i.Sort()
+
+	var (
+		data []byte
+		err  error
+	)
+
+	if isNDJSON(path) {
+		data, err = i.marshalNDJSON()
+	} else {
+		data, err = json.MarshalIndent(i, "", "  ")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "can not marshal inventory")
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil { //nolint:gosec,mnd
+		return errors.Wrap(err, "can not write inventory")
+	}
+
+	return nil
+}
+
+func (i *Inventory) marshalNDJSON() ([]byte, error) {
+	records := []record{{Kind: kindHeader, Header: &i.Header}}
+
+	for _, repository := range i.Repositories {
+		records = append(records, record{Kind: kindRepository, Repository: repository})
+	}
+
+	for _, project := range i.Projects {
+		records = append(records, record{Kind: kindProject, Project: project})
+	}
+
+	for _, group := range i.Groups {
+		records = append(records, record{Kind: kindGroup, Group: group})
+	}
+
+	var buffer bytes.Buffer
+
+	encoder := json.NewEncoder(&buffer)
+
+	for _, item := range records {
+		if err := encoder.Encode(item); err != nil {
+			return nil, errors.Wrap(err, item.Kind)
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func unmarshalNDJSON(data []byte) (*Inventory, error) {
+	result := Inventory{}
+	line := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	for scanner.Scan() {
+		line++
+
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		item := record{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+
+		switch {
+		case item.Kind == kindHeader && item.Header != nil && line == 1:
+			result.Header = *item.Header
+		case item.Kind == kindRepository && item.Repository != nil:
+			result.Repositories = append(result.Repositories, item.Repository)
+		case item.Kind == kindProject && item.Project != nil:
+			result.Projects = append(result.Projects, item.Project)
+		case item.Kind == kindGroup && item.Group != nil:
+			result.Groups = append(result.Groups, item.Group)
+		default:
+			return nil, errors.Errorf("line %d: unknown record %s", line, item.Kind)
+		}
+	}
+
+	return &result, errors.Wrap(scanner.Err(), "can not read lines")
+}
+
+// Load inventory from json or ndjson file.
+func Load(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read inventory")
+	}
+
+	result := &Inventory{}
+
+	if isNDJSON(path) {
+		result, err = unmarshalNDJSON(data)
+	} else {
+		err = json.Unmarshal(data, result)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "can not parse inventory")
+	}
+
+	if result.Version != Version {
+		return nil, errors.Errorf("inventory version %d is not supported, need %d", result.Version, Version)
+	}
+
+	result.repositories = make(map[string]*Repository)
+	result.projects = make(map[string]*Project)
+	result.projectIDs = make(map[int]*Project)
+	result.groups = make(map[string]*Group)
+
+	for n, repository := range result.Repositories {
+		if len(repository.Repository) == 0 {
+			return nil, errors.Errorf("repositories[%d]: repository must be set", n)
+		}
+
+		result.repositories[repository.Repository] = repository
+	}
+
+	for n, project := range result.Projects {
+		if len(project.Path) == 0 {
+			return nil, errors.Errorf("projects[%d]: path must be set", n)
+		}
+
+		result.projects[project.Path] = project
+
+		// old and new paths of moved project have the same data
+		if _, ok := result.projectIDs[project.ID]; !ok && project.ID > 0 {
+			result.projectIDs[project.ID] = project
+		}
+	}
+
+	for n, group := range result.Groups {
+		if len(group.Path) == 0 {
+			return nil, errors.Errorf("groups[%d]: path must be set", n)
+		}
+
+		result.groups[group.Path] = group
+	}
+
+	return result, nil
+}
@@ -0,0 +1,334 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package inventory_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/gitlab"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/inventory"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	"github.com/pkg/errors"
+)
+
+func testInventory() *inventory.Inventory {
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	result := inventory.New("docker")
+	result.Details = true
+	result.Index = true
+	result.Repositories = append(result.Repositories,
+		&inventory.Repository{
+			Repository: "group/project/b",
+			Error:      "can not list tags",
+			Tags:       []*inventory.Tag{},
+		},
+		&inventory.Repository{
+			Repository: "group/project/a",
+			Tags: []*inventory.Tag{
+				{Tag: "main", Digest: "sha256:2", Details: &inventory.Details{MediaType: "index", Created: &created, Size: 10}},
+				{Tag: "feature", Digest: "sha256:1"},
+				{Tag: "broken"},
+			},
+			Children: map[string][]string{
+				"sha256:1": {},
+				"sha256:2": {"sha256:amd64", "sha256:arm64"},
+			},
+		},
+		&inventory.Repository{
+			Repository: "tools/image",
+			Project:    "tools/cli",
+			Tags:       []*inventory.Tag{},
+		},
+	)
+	result.Projects = append(result.Projects,
+		&inventory.Project{
+			Path: "group/project",
+			ID:   1,
+			Branches: []*types.Branch{
+				{Name: "feature/test", LastCommitDate: created},
+				{Name: "main", LastCommitDate: created},
+			},
+			OpenMergeRequests: []*types.MergeRequest{
+				{IID: 1, SourceBranch: "feature/test"},
+			},
+			Deployments: []*types.Deployment{
+				{Environment: "production", Ref: "main", SHA: "0123456789abcdef"},
+			},
+		},
+		&inventory.Project{Path: "group/removed", NotFound: true},
+		&inventory.Project{Path: "group/failed", ID: 2, Error: "can not get branches"},
+		&inventory.Project{Path: "group/archived", ID: 4, Archived: true},
+		&inventory.Project{Path: "old/project", ID: 1, PathWithNamespace: "group/project"},
+		&inventory.Project{Path: "group/forbidden", Error: "can not get project"},
+	)
+	result.Groups = append(result.Groups,
+		&inventory.Group{Path: "group", ReadProjects: true},
+		&inventory.Group{Path: "denied"},
+		&inventory.Group{Path: "failed", Error: "can not get group member"},
+	)
+
+	return result
+}
+
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"inventory.json", "inventory.ndjson"} {
+		path := filepath.Join(t.TempDir(), name)
+
+		result := testInventory()
+
+		if err := result.Save(path); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := inventory.Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if loaded.Repositories[0].Repository != "group/project/a" || loaded.Repositories[0].Tags[0].Tag != "broken" {
+			t.Fatalf("%s: inventory must be sorted", name)
+		}
+
+		if !reflect.DeepEqual(loaded.Header, result.Header) {
+			t.Fatalf("%s: header not equals (%+v)<=loaded (%+v)<=saved", name, loaded.Header, result.Header)
+		}
+
+		if !reflect.DeepEqual(loaded.Repositories, result.Repositories) || !reflect.DeepEqual(loaded.Projects, result.Projects) || !reflect.DeepEqual(loaded.Groups, result.Groups) { //nolint:lll
+			t.Fatalf("%s: inventory not equals", name)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"version.json":        `{"version": 999}`,
+		"repository.json":     `{"version": 1, "repositories": [{"tags": []}]}`,
+		"project.json":        `{"version": 1, "projects": [{"id": 1}]}`,
+		"json.json":           `not json`,
+		"header.ndjson":       `{"kind": "repository", "repository": {"repository": "group/project/a"}}`,
+		"kind.ndjson":         "{\"kind\": \"header\", \"header\": {\"version\": 1}}\n{\"kind\": \"fake\"}",
+		"repository.ndjson":   "{\"kind\": \"header\", \"header\": {\"version\": 1}}\n{\"kind\": \"repository\"}",
+		"json.ndjson":         "{\"kind\": \"header\", \"header\": {\"version\": 1}}\nnot json",
+		"emptyheader.ndjson":  `{"kind": "header"}`,
+		"missingfile.missing": "",
+	}
+
+	for name, test := range tests {
+		path := filepath.Join(t.TempDir(), name)
+
+		if name != "missingfile.missing" {
+			if err := os.WriteFile(path, []byte(test), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := inventory.Load(path); err == nil {
+			t.Fatalf("%s must return error", name)
+		}
+	}
+}
+
+func loadInventory(t *testing.T, result *inventory.Inventory) *inventory.Inventory {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "inventory.json")
+
+	if err := result.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := inventory.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loaded
+}
+
+func TestProvider(t *testing.T) { //nolint:cyclop,funlen
+	t.Parallel()
+
+	ctx := context.Background()
+	provider := inventory.NewProvider(loadInventory(t, testInventory()))
+
+	repositories, err := provider.Repositories(ctx, "^group/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result := strings.Join(repositories, ","); result != "group/project/a,group/project/b" {
+		t.Fatalf("repositories %s are not correct", result)
+	}
+
+	tags, err := provider.Tags(ctx, "group/project/a")
+	if err != nil || strings.Join(tags, ",") != "broken,feature,main" {
+		t.Fatalf("tags %v are not correct, %v", tags, err)
+	}
+
+	for _, repository := range []string{"group/project/b", "unknown"} {
+		if _, err := provider.Tags(ctx, repository); err == nil {
+			t.Fatalf("%s must return error", repository)
+		}
+	}
+
+	digest, err := provider.Digest(ctx, "group/project/a", "main")
+	if err != nil || digest != "sha256:2" {
+		t.Fatalf("digest %s is not correct, %v", digest, err)
+	}
+
+	for _, tag := range []string{"broken", "unknown"} {
+		if _, err := provider.Digest(ctx, "group/project/a", tag); err == nil {
+			t.Fatalf("%s must return error", tag)
+		}
+	}
+
+	projectProvider, ok := provider.(types.ProjectProvider)
+	if !ok {
+		t.Fatal("provider must return projects")
+	}
+
+	for repository, project := range map[string]string{"tools/image": "tools/cli", "group/project/a": ""} {
+		if result, err := projectProvider.Project(ctx, repository); err != nil || result != project {
+			t.Fatalf("project %s of %s is not correct, %v", result, repository, err)
+		}
+	}
+
+	detailsProvider, ok := provider.(types.DetailsProvider)
+	if !ok {
+		t.Fatal("provider must return details")
+	}
+
+	details, err := detailsProvider.TagDetails(ctx, "group/project/a", "main")
+	if err != nil || details.Digest != "sha256:2" || details.MediaType != "index" || details.Size != 10 {
+		t.Fatalf("details %+v are not correct, %v", details, err)
+	}
+
+	if _, err := detailsProvider.TagDetails(ctx, "group/project/a", "feature"); err == nil {
+		t.Fatal("tag without details must return error")
+	}
+
+	indexProvider, ok := provider.(types.IndexProvider)
+	if !ok {
+		t.Fatal("provider must return children")
+	}
+
+	children, err := indexProvider.Children(ctx, "group/project/a", "sha256:2")
+	if err != nil || strings.Join(children, ",") != "sha256:amd64,sha256:arm64" {
+		t.Fatalf("children %v are not correct, %v", children, err)
+	}
+
+	if _, err := indexProvider.Children(ctx, "group/project/a", "sha256:unknown"); err == nil {
+		t.Fatal("unknown manifest must return error")
+	}
+
+	if err := provider.DeleteTag(ctx, types.DeleteTagInput{Repository: "group/project/a", Tag: "main"}); err == nil {
+		t.Fatal("inventory must be read only")
+	}
+
+	// optional interfaces depend on exported provider
+	result := testInventory()
+	result.Index = false
+	result.Details = false
+
+	provider = inventory.NewProvider(loadInventory(t, result))
+
+	if _, ok := provider.(types.DetailsProvider); ok {
+		t.Fatal("provider must not return details")
+	}
+
+	if _, ok := provider.(types.IndexProvider); ok {
+		t.Fatal("provider must not return children")
+	}
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	source := gitlab.Source(loadInventory(t, testInventory()))
+
+	project, err := source.GetProject(ctx, "group/project")
+	if err != nil || project.ID != 1 || project.Archived {
+		t.Fatalf("project %+v is not correct, %v", project, err)
+	}
+
+	project, err = source.GetProject(ctx, "old/project")
+	if err != nil || project.ID != 1 || project.Path != "group/project" {
+		t.Fatalf("moved project must return current path (%+v, %v)", project, err)
+	}
+
+	project, err = source.GetProject(ctx, "group/archived")
+	if err != nil || project.ID != 4 || !project.Archived {
+		t.Fatalf("project %+v is not correct, %v", project, err)
+	}
+
+	for _, path := range []string{"group/removed", "group/unknown"} {
+		if _, err := source.GetProject(ctx, path); !errors.Is(err, gitlab.ErrNotFound) {
+			t.Fatalf("%s must not be found, %v", path, err)
+		}
+	}
+
+	if _, err := source.GetProject(ctx, "group/forbidden"); err == nil || errors.Is(err, gitlab.ErrNotFound) {
+		t.Fatalf("project with error must return error, %v", err)
+	}
+
+	for group, need := range map[string]bool{"group": true, "denied": false, "unknown": false} {
+		if readProjects, err := source.CanReadGroupProjects(ctx, group); err != nil || readProjects != need {
+			t.Fatalf("%s access %v need %v, %v", group, readProjects, need, err)
+		}
+	}
+
+	if _, err := source.CanReadGroupProjects(ctx, "failed"); err == nil {
+		t.Fatal("group with error must return error")
+	}
+
+	branches, err := source.GetProjectBranches(ctx, 1)
+	if err != nil || branches["feature-test"] == nil || branches["main"] == nil {
+		t.Fatalf("branches %v are not correct, %v", branches, err)
+	}
+
+	mergeRequests, err := source.GetProjectOpenMergeRequests(ctx, 1)
+	if err != nil || mergeRequests["feature-test"] == nil {
+		t.Fatalf("merge requests %v are not correct, %v", mergeRequests, err)
+	}
+
+	deployments, err := source.GetProjectDeployments(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"main", "0123456789abcdef", "01234567"} {
+		if len(deployments[key]) != 1 {
+			t.Fatalf("deployment %s not found in %v", key, deployments)
+		}
+	}
+
+	if _, err := source.GetProjectBranches(ctx, 2); err == nil {
+		t.Fatal("project with error must return error")
+	}
+
+	if _, err := source.GetProjectDeployments(ctx, 3); err == nil {
+		t.Fatal("unknown project must return error")
+	}
+}
@@ -0,0 +1,288 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package inventory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/gitlab"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// Provider reads repositories and tags from loaded inventory, it can not delete tags.
+type Provider struct {
+	inventory *Inventory
+}
+
+// provider of inventory with manifest details.
+type detailsProvider struct {
+	*Provider
+}
+
+// provider of inventory with manifest details and platform manifests of indexes.
+type indexProvider struct {
+	*detailsProvider
+}
+
+// NewProvider returns provider with optional interfaces of provider that exported inventory.
+func NewProvider(inventory *Inventory) types.Provider { //nolint:ireturn
+	provider := &Provider{inventory: inventory}
+
+	switch {
+	case inventory.Index:
+		return &indexProvider{&detailsProvider{provider}}
+	case inventory.Details:
+		return &detailsProvider{provider}
+	default:
+		return provider
+	}
+}
+
+// Provider of inventory deletes tags in the same way as exported provider.
+func (p *Provider) DeletesManifest() bool {
+	return p.inventory.Manifests
+}
+
+func (p *Provider) Init(_ context.Context, _ bool) error {
+	return nil
+}
+
+// List repositories.
+func (p *Provider) Repositories(_ context.Context, filter string) ([]string, error) {
+	repos := make([]string, 0, len(p.inventory.Repositories))
+
+	for _, repository := range p.inventory.Repositories {
+		repos = append(repos, repository.Repository)
+	}
+
+	sort.Strings(repos)
+
+	if len(filter) > 0 {
+		return utils.FilterStrings(repos, filter), nil
+	}
+
+	return repos, nil
+}
+
+func (p *Provider) getRepository(repository string) (*Repository, error) {
+	result, ok := p.inventory.repositories[repository]
+	if !ok {
+		return nil, errors.Errorf("repository %s not found in inventory", repository)
+	}
+
+	if len(result.Error) > 0 {
+		return nil, errors.Errorf("%s: %s", repository, result.Error)
+	}
+
+	return result, nil
+}
+
+func (p *Provider) getTag(repository, tag string) (*Tag, error) {
+	result, err := p.getRepository(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range result.Tags {
+		if item.Tag == tag {
+			return item, nil
+		}
+	}
+
+	return nil, errors.Errorf("tag %s:%s not found in inventory", repository, tag)
+}
+
+// Get gitlab project path of repository, path is empty if provider did not export it.
+func (p *Provider) Project(_ context.Context, repository string) (string, error) {
+	result, ok := p.inventory.repositories[repository]
+	if !ok {
+		return "", errors.Errorf("repository %s not found in inventory", repository)
+	}
+
+	return result.Project, nil
+}
+
+// List tags.
+func (p *Provider) Tags(_ context.Context, repository string) ([]string, error) {
+	result, err := p.getRepository(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, 0, len(result.Tags))
+
+	for _, tag := range result.Tags {
+		tags = append(tags, tag.Tag)
+	}
+
+	return tags, nil
+}
+
+// Get manifest digest.
+func (p *Provider) Digest(_ context.Context, repository string, tag string) (string, error) {
+	result, err := p.getTag(repository, tag)
+	if err != nil {
+		return "", err
+	}
+
+	if len(result.Digest) == 0 {
+		return "", errors.Errorf("tag %s:%s has no digest in inventory", repository, tag)
+	}
+
+	return result.Digest, nil
+}
+
+func (p *Provider) DeleteTag(_ context.Context, deleteTag types.DeleteTagInput) error {
+	return errors.Errorf("%s:%s can not be deleted from inventory", deleteTag.Repository, deleteTag.Tag)
+}
+
+func (p *Provider) PostCommand(_ context.Context) error {
+	return nil
+}
+
+// Get manifest details.
+func (p *detailsProvider) TagDetails(_ context.Context, repository string, tag string) (*types.TagDetails, error) {
+	result, err := p.getTag(repository, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Details == nil || len(result.Digest) == 0 {
+		return nil, errors.Errorf("tag %s:%s has no details in inventory", repository, tag)
+	}
+
+	details := types.TagDetails{
+		Digest:    result.Digest,
+		MediaType: result.Details.MediaType,
+		Size:      result.Details.Size,
+	}
+
+	if result.Details.Created != nil {
+		details.Created = *result.Details.Created
+	}
+
+	return &details, nil
+}
+
+// Get platform manifests of index.
+func (p *indexProvider) Children(_ context.Context, repository string, digest string) ([]string, error) {
+	result, err := p.getRepository(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	children, ok := result.Children[digest]
+	if !ok {
+		return nil, errors.Errorf("manifest %s of %s not found in inventory", digest, repository)
+	}
+
+	return children, nil
+}
+
+func (i *Inventory) getProject(projectID int) (*Project, error) {
+	result, ok := i.projectIDs[projectID]
+	if !ok {
+		return nil, errors.Errorf("project %d not found in inventory", projectID)
+	}
+
+	if len(result.Error) > 0 {
+		return nil, errors.Errorf("%s: %s", result.Path, result.Error)
+	}
+
+	return result, nil
+}
+
+// GetProject returns project, projects that were not exported are not found.
+func (i *Inventory) GetProject(_ context.Context, path string) (*types.GitlabProject, error) {
+	result, ok := i.projects[path]
+	if !ok || result.NotFound {
+		return nil, errors.Wrap(gitlab.ErrNotFound, path)
+	}
+
+	if result.ID == 0 {
+		return nil, errors.Errorf("%s: %s", path, result.Error)
+	}
+
+	return &types.GitlabProject{
+		ID:       result.ID,
+		Path:     result.PathWithNamespace,
+		Archived: result.Archived,
+	}, nil
+}
+
+// CanReadGroupProjects returns access of token to group, groups that were not exported are not readable.
+func (i *Inventory) CanReadGroupProjects(_ context.Context, group string) (bool, error) {
+	result, ok := i.groups[group]
+	if !ok {
+		return false, nil
+	}
+
+	if len(result.Error) > 0 {
+		return false, errors.Errorf("%s: %s", group, result.Error)
+	}
+
+	return result.ReadProjects, nil
+}
+
+// GetProjectBranches returns branches by slugname.
+func (i *Inventory) GetProjectBranches(_ context.Context, projectID int) (map[string]*types.Branch, error) {
+	project, err := i.getProject(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*types.Branch)
+
+	for _, branch := range project.Branches {
+		result[utils.GitlabSluglify(branch.Name)] = branch
+	}
+
+	return result, nil
+}
+
+// GetProjectOpenMergeRequests returns open merge requests by source branch slugname.
+func (i *Inventory) GetProjectOpenMergeRequests(_ context.Context, projectID int) (map[string]*types.MergeRequest, error) { //nolint:lll
+	project, err := i.getProject(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*types.MergeRequest)
+
+	for _, mergeRequest := range project.OpenMergeRequests {
+		result[utils.GitlabSluglify(mergeRequest.SourceBranch)] = mergeRequest
+	}
+
+	return result, nil
+}
+
+// GetProjectDeployments returns last successful deployments by ref slugname, commit sha and short commit sha.
+func (i *Inventory) GetProjectDeployments(_ context.Context, projectID int) (map[string][]*types.Deployment, error) {
+	project, err := i.getProject(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]*types.Deployment)
+
+	for _, deployment := range project.Deployments {
+		for _, key := range gitlab.DeploymentKeys(deployment) {
+			result[key] = append(result[key], deployment)
+		}
+	}
+
+	return result, nil
+}
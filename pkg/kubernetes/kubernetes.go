@@ -0,0 +1,281 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const inClusterName = "in-cluster"
+
+// pod status image id can contain runtime prefix.
+var imageIDPrefixes = []string{"docker-pullable://", "docker://"}
+
+// Images that are used in kubernetes clusters.
+type Images struct {
+	// registry host, images from other hosts are ignored
+	registry string
+	// sources of images by repository:tag
+	tags map[string][]string
+	// sources of images by repository@digest
+	digests map[string][]string
+	// repositories with images referenced by digest
+	digestRepositories map[string]bool
+}
+
+func NewImages(registry string) *Images {
+	return &Images{
+		registry:           registry,
+		tags:               make(map[string][]string),
+		digests:            make(map[string][]string),
+		digestRepositories: make(map[string]bool),
+	}
+}
+
+// Add image reference that is used by source.
+func (i *Images) Add(image, source string) error {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return errors.Wrap(err, image)
+	}
+
+	if len(i.registry) > 0 && reference.Domain(named) != i.registry {
+		return nil
+	}
+
+	repository := reference.Path(named)
+
+	if tagged, ok := named.(reference.Tagged); ok {
+		key := repository + ":" + tagged.Tag()
+		i.tags[key] = appendSource(i.tags[key], source)
+	}
+
+	if digested, ok := named.(reference.Digested); ok {
+		key := repository + "@" + digested.Digest().String()
+		i.digests[key] = appendSource(i.digests[key], source)
+		i.digestRepositories[repository] = true
+	}
+
+	return nil
+}
+
+// Tag returns sources that use repository tag.
+func (i *Images) Tag(repository, tag string) []string {
+	return i.tags[repository+":"+tag]
+}
+
+// Digest returns sources that use repository manifest digest.
+func (i *Images) Digest(repository, digest string) []string {
+	return i.digests[repository+"@"+digest]
+}
+
+// HasDigests returns true if some images of repository are referenced by digest.
+func (i *Images) HasDigests(repository string) bool {
+	return i.digestRepositories[repository]
+}
+
+func appendSource(sources []string, source string) []string {
+	for _, item := range sources {
+		if item == source {
+			return sources
+		}
+	}
+
+	sources = append(sources, source)
+
+	sort.Strings(sources)
+
+	return sources
+}
+
+// Get images that are used in configured clusters, unreachable clusters are skipped.
+func GetImages(ctx context.Context) (*Images, []error) {
+	images := NewImages(config.Get().Kubernetes.Registry)
+	clusterErrors := make([]error, 0)
+
+	clusters, err := getClusters()
+	if err != nil {
+		return images, append(clusterErrors, err)
+	}
+
+	for cluster, clientset := range clusters {
+		log.Infof("collecting images from %s cluster", cluster)
+
+		if err := CollectImages(ctx, cluster, clientset, images); err != nil {
+			clusterErrors = append(clusterErrors, errors.Wrap(err, cluster))
+		}
+	}
+
+	return images, clusterErrors
+}
+
+// create clients for kubeconfig contexts or in-cluster client.
+func getClusters() (map[string]kubernetes.Interface, error) {
+	kubeconfig := config.Get().Kubernetes.Kubeconfig
+	contexts := make([]string, 0)
+
+	for _, item := range strings.Split(config.Get().Kubernetes.Contexts, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			contexts = append(contexts, item)
+		}
+	}
+
+	clusters := make(map[string]kubernetes.Interface)
+
+	if len(kubeconfig) == 0 && len(contexts) == 0 {
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "can not get in-cluster config")
+		}
+
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not create clientset")
+		}
+
+		clusters[inClusterName] = clientset
+
+		return clusters, nil
+	}
+
+	// empty context means current context of kubeconfig
+	if len(contexts) == 0 {
+		contexts = append(contexts, "")
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+
+	for _, kubeContext := range contexts {
+		restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			loadingRules,
+			&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+		).ClientConfig()
+		if err != nil {
+			return nil, errors.Wrapf(err, "can not get config of context %q", kubeContext)
+		}
+
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not create clientset")
+		}
+
+		if len(kubeContext) == 0 {
+			kubeContext = "current-context"
+		}
+
+		clusters[kubeContext] = clientset
+	}
+
+	return clusters, nil
+}
+
+// CollectImages adds images of pods, deployments, statefulsets, daemonsets and cronjobs in all namespaces.
+func CollectImages(ctx context.Context, cluster string, clientset kubernetes.Interface, images *Images) error { //nolint:funlen,lll
+	addPodSpec := func(kind string, meta metav1.ObjectMeta, spec corev1.PodSpec) {
+		source := fmt.Sprintf("%s/%s %s/%s", cluster, kind, meta.Namespace, meta.Name)
+
+		containers := make([]corev1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+		containers = append(containers, spec.InitContainers...)
+		containers = append(containers, spec.Containers...)
+
+		for _, container := range containers {
+			if err := images.Add(container.Image, source); err != nil {
+				log.WithError(err).Warnf("%s invalid image", source)
+			}
+		}
+	}
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "can not list pods")
+	}
+
+	for _, pod := range pods.Items {
+		addPodSpec("pod", pod.ObjectMeta, pod.Spec)
+
+		source := fmt.Sprintf("%s/pod %s/%s", cluster, pod.Namespace, pod.Name)
+
+		// running image can be referenced by digest only in status
+		statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses)) //nolint:lll
+		statuses = append(statuses, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+
+		for _, status := range statuses {
+			imageID := status.ImageID
+			for _, prefix := range imageIDPrefixes {
+				imageID = strings.TrimPrefix(imageID, prefix)
+			}
+
+			// image id without repository is local image id
+			if len(imageID) == 0 || strings.HasPrefix(imageID, "sha256:") {
+				continue
+			}
+
+			if err := images.Add(imageID, source); err != nil {
+				log.WithError(err).Debugf("%s invalid image id", source)
+			}
+		}
+	}
+
+	deployments, err := clientset.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "can not list deployments")
+	}
+
+	for _, item := range deployments.Items {
+		addPodSpec("deployment", item.ObjectMeta, item.Spec.Template.Spec)
+	}
+
+	statefulsets, err := clientset.AppsV1().StatefulSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "can not list statefulsets")
+	}
+
+	for _, item := range statefulsets.Items {
+		addPodSpec("statefulset", item.ObjectMeta, item.Spec.Template.Spec)
+	}
+
+	daemonsets, err := clientset.AppsV1().DaemonSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "can not list daemonsets")
+	}
+
+	for _, item := range daemonsets.Items {
+		addPodSpec("daemonset", item.ObjectMeta, item.Spec.Template.Spec)
+	}
+
+	cronjobs, err := clientset.BatchV1().CronJobs(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "can not list cronjobs")
+	}
+
+	for _, item := range cronjobs.Items {
+		addPodSpec("cronjob", item.ObjectMeta, item.Spec.JobTemplate.Spec.Template.Spec)
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package kubernetes_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/kubernetes"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testDigest = "sha256:4c1e2ea2fd6ad5f0a71c3d6dbf1e2c3b2c1f1a5d9f1e1a2b3c4d5e6f7a8b9c0d"
+
+func podSpec(images ...string) corev1.PodSpec {
+	spec := corev1.PodSpec{}
+
+	for _, image := range images {
+		spec.Containers = append(spec.Containers, corev1.Container{Name: "app", Image: image})
+	}
+
+	return spec
+}
+
+func TestCollectImages(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app-1"},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", Image: "registry.test/group/project/init:main"}},
+				Containers:     []corev1.Container{{Name: "app", Image: "registry.test/group/project/app:feature-new"}},
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:    "app",
+					ImageID: "docker-pullable://registry.test/group/project/app@" + testDigest,
+				}},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{Spec: podSpec("registry.test/group/project/app:release-20230101")},
+			},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "mysql"},
+			Spec: appsv1.StatefulSetSpec{
+				Template: corev1.PodTemplateSpec{Spec: podSpec("registry.test/devops/docker/mysql:20230101-snap")},
+			},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "report"},
+			Spec: batchv1.CronJobSpec{
+				JobTemplate: batchv1.JobTemplateSpec{
+					Spec: batchv1.JobSpec{
+						Template: corev1.PodTemplateSpec{Spec: podSpec("registry.test/group/project/report:feature-old")},
+					},
+				},
+			},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "agent"},
+			Spec: appsv1.DaemonSetSpec{
+				Template: corev1.PodTemplateSpec{Spec: podSpec("docker.io/group/project/app:feature-other")},
+			},
+		},
+	)
+
+	images := kubernetes.NewImages("registry.test")
+
+	if err := kubernetes.CollectImages(context.Background(), "test", clientset, images); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := make(map[string]string)
+
+	tests["group/project/init:main"] = "test/pod default/app-1"
+	tests["group/project/app:feature-new"] = "test/pod default/app-1"
+	tests["group/project/app:release-20230101"] = "test/deployment default/app"
+	tests["devops/docker/mysql:20230101-snap"] = "test/statefulset db/mysql"
+	tests["group/project/report:feature-old"] = "test/cronjob default/report"
+
+	for image, source := range tests {
+		repository, tag, _ := strings.Cut(image, ":")
+
+		if sources := images.Tag(repository, tag); len(sources) != 1 || sources[0] != source {
+			t.Fatalf("%s sources %v need %s", image, sources, source)
+		}
+	}
+
+	// image from other registry must be ignored
+	if sources := images.Tag("group/project/app", "feature-other"); len(sources) != 0 {
+		t.Fatalf("sources %v must be empty", sources)
+	}
+
+	if !images.HasDigests("group/project/app") {
+		t.Fatal("group/project/app must have digests")
+	}
+
+	if sources := images.Digest("group/project/app", testDigest); len(sources) != 1 {
+		t.Fatalf("digest sources %v must be found", sources)
+	}
+}
+
+func TestCollectImagesError(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+
+	if err := kubernetes.CollectImages(context.Background(), "test", clientset, kubernetes.NewImages("")); err == nil {
+		t.Fatal("unreachable cluster must return error")
+	}
+}
+
+func TestImagesAdd(t *testing.T) {
+	t.Parallel()
+
+	images := kubernetes.NewImages("")
+
+	if err := images.Add("nginx:1.25", "test/pod default/nginx"); err != nil {
+		t.Fatal(err)
+	}
+
+	// source must not be duplicated
+	if err := images.Add("docker.io/library/nginx:1.25", "test/pod default/nginx"); err != nil {
+		t.Fatal(err)
+	}
+
+	if sources := images.Tag("library/nginx", "1.25"); len(sources) != 1 {
+		t.Fatalf("sources %v must contain one item", sources)
+	}
+
+	if err := images.Add("Invalid:Image:", "test/pod default/invalid"); err == nil {
+		t.Fatal("invalid image must return error")
+	}
+}
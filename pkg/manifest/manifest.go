@@ -0,0 +1,221 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type FSLayer struct {
+	BlobSum string `json:"blobSum"`
+}
+
+// Manifest is common fields of docker schema1, schema2, manifest list and oci image manifest, index.
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        *Descriptor  `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+	Manifests     []Descriptor `json:"manifests"`
+	FSLayers      []FSLayer    `json:"fsLayers"`
+	Subject       *Descriptor  `json:"subject"`
+}
+
+func Parse(data []byte) (*Manifest, error) {
+	result := Manifest{}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, errors.Wrap(err, "can not parse manifest")
+	}
+
+	if result.SchemaVersion == 0 {
+		return nil, errors.New("manifest must contain schemaVersion")
+	}
+
+	return &result, nil
+}
+
+// IsIndex returns true for manifest list or oci index.
+func (m *Manifest) IsIndex() bool {
+	return m.MediaType == MediaTypeDockerManifestList || m.MediaType == MediaTypeOCIIndex || len(m.Manifests) > 0
+}
+
+// Blobs returns digests of config and layers.
+func (m *Manifest) Blobs() []string {
+	result := make([]string, 0, len(m.Layers)+len(m.FSLayers)+1)
+
+	if m.Config != nil && len(m.Config.Digest) > 0 {
+		result = append(result, m.Config.Digest)
+	}
+
+	for _, layer := range m.Layers {
+		result = append(result, layer.Digest)
+	}
+
+	for _, layer := range m.FSLayers {
+		result = append(result, layer.BlobSum)
+	}
+
+	return result
+}
+
+// Children returns digests of manifests in index.
+func (m *Manifest) Children() []string {
+	result := make([]string, 0, len(m.Manifests))
+
+	for _, child := range m.Manifests {
+		result = append(result, child.Digest)
+	}
+
+	return result
+}
+
+// Size returns compressed size of config and layers.
+func (m *Manifest) Size() int64 {
+	var result int64
+
+	if m.Config != nil {
+		result += m.Config.Size
+	}
+
+	for _, layer := range m.Layers {
+		result += layer.Size
+	}
+
+	return result
+}
+
+// ImageConfig is fields of image config that are used by cleaner.
+type ImageConfig struct {
+	Created time.Time `json:"created"`
+}
+
+func ParseConfig(data []byte) (*ImageConfig, error) {
+	result := ImageConfig{}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, errors.Wrap(err, "can not parse image config")
+	}
+
+	return &result, nil
+}
+
+// Fetcher reads manifests and blobs of repository.
+type Fetcher interface {
+	// Get manifest by tag or digest
+	GetManifest(ctx context.Context, repository, reference string) ([]byte, error)
+	// Get blob content
+	GetBlob(ctx context.Context, repository, digest string) ([]byte, error)
+}
+
+// GetDetails reads manifest and image config, index details are calculated from its manifests.
+func GetDetails(ctx context.Context, fetcher Fetcher, repository, reference string) (*types.TagDetails, error) {
+	data, err := fetcher.GetManifest(ctx, repository, reference)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not get manifest %s", reference)
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		return nil, errors.Wrap(err, reference)
+	}
+
+	result := types.TagDetails{
+		Digest:    godigest.FromBytes(data).String(),
+		MediaType: parsed.MediaType,
+	}
+
+	if parsed.IsIndex() {
+		for _, child := range parsed.Children() {
+			details, err := GetDetails(ctx, fetcher, repository, child)
+			if err != nil {
+				return nil, err
+			}
+
+			result.Size += details.Size
+
+			if details.Created.After(result.Created) {
+				result.Created = details.Created
+			}
+		}
+
+		return &result, nil
+	}
+
+	result.Size = parsed.Size()
+
+	// schema1 manifest has no config
+	if parsed.Config == nil || len(parsed.Config.Digest) == 0 {
+		return &result, nil
+	}
+
+	data, err = fetcher.GetBlob(ctx, repository, parsed.Config.Digest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not get config %s", parsed.Config.Digest)
+	}
+
+	config, err := ParseConfig(data)
+	if err != nil {
+		return nil, errors.Wrap(err, parsed.Config.Digest)
+	}
+
+	result.Created = config.Created
+
+	return &result, nil
+}
+
+// GetChildren returns digests of manifests of index recursively, image manifest has no children.
+func GetChildren(ctx context.Context, fetcher Fetcher, repository, digest string) ([]string, error) {
+	data, err := fetcher.GetManifest(ctx, repository, digest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not get manifest %s", digest)
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		return nil, errors.Wrap(err, digest)
+	}
+
+	result := make([]string, 0)
+
+	for _, child := range parsed.Children() {
+		children, err := GetChildren(ctx, fetcher, repository, child)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, child)
+		result = append(result, children...)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,191 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package manifest_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/manifest"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	image, err := manifest.Parse([]byte(`{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+  "config": {"mediaType": "application/vnd.docker.container.image.v1+json", "digest": "sha256:c1", "size": 10},
+  "layers": [
+    {"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "digest": "sha256:l1", "size": 100},
+    {"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "digest": "sha256:l2", "size": 200}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.IsIndex() {
+		t.Fatal("image must not be index")
+	}
+
+	if blobs := strings.Join(image.Blobs(), ","); blobs != "sha256:c1,sha256:l1,sha256:l2" {
+		t.Fatalf("blobs %s are not correct", blobs)
+	}
+
+	index, err := manifest.Parse([]byte(`{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.index.v1+json",
+  "manifests": [
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:amd64", "size": 500},
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:arm64", "size": 500}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !index.IsIndex() || len(index.Blobs()) != 0 {
+		t.Fatal("index must not contain blobs")
+	}
+
+	if children := strings.Join(index.Children(), ","); children != "sha256:amd64,sha256:arm64" {
+		t.Fatalf("children %s are not correct", children)
+	}
+
+	schema1, err := manifest.Parse([]byte(`{"schemaVersion": 1, "fsLayers": [{"blobSum": "sha256:l1"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blobs := strings.Join(schema1.Blobs(), ","); blobs != "sha256:l1" {
+		t.Fatalf("blobs %s are not correct", blobs)
+	}
+
+	for _, data := range []string{`{}`, `not json`} {
+		if _, err := manifest.Parse([]byte(data)); err == nil {
+			t.Fatalf("%s must return error", data)
+		}
+	}
+}
+
+type fetcher map[string]string
+
+func (f fetcher) GetManifest(_ context.Context, _, reference string) ([]byte, error) {
+	return f.GetBlob(context.Background(), "", reference)
+}
+
+func (f fetcher) GetBlob(_ context.Context, _, digest string) ([]byte, error) {
+	data, ok := f[digest]
+	if !ok {
+		return nil, errors.New(digest + " not found")
+	}
+
+	return []byte(data), nil
+}
+
+func TestGetDetails(t *testing.T) {
+	t.Parallel()
+
+	amd64 := `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json",
+"config": {"digest": "sha256:c1", "size": 10}, "layers": [{"digest": "sha256:l1", "size": 100}]}`
+	arm64 := `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json",
+"config": {"digest": "sha256:c2", "size": 20}, "layers": [{"digest": "sha256:l2", "size": 200}]}`
+	index := `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.index.v1+json",
+"manifests": [{"digest": "amd64"}, {"digest": "arm64"}]}`
+
+	test := fetcher{
+		"amd64":       amd64,
+		"arm64":       arm64,
+		"index":       index,
+		"sha256:c1":   `{"created": "2023-01-01T00:00:00Z"}`,
+		"sha256:c2":   `{"created": "2023-02-01T00:00:00Z"}`,
+		"schema1":     `{"schemaVersion": 1, "fsLayers": [{"blobSum": "sha256:l1"}]}`,
+		"noconfig":    `{"schemaVersion": 2, "config": {"digest": "sha256:missing"}}`,
+		"notmanifest": `{}`,
+	}
+
+	details, err := manifest.GetDetails(context.Background(), test, "image", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if details.Digest != godigest.FromString(amd64).String() || details.MediaType != manifest.MediaTypeOCIManifest {
+		t.Fatalf("details %+v are not correct", details)
+	}
+
+	if details.Size != 110 || !details.Created.Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("details %+v are not correct", details)
+	}
+
+	// index has newest creation time and size of all images
+	details, err = manifest.GetDetails(context.Background(), test, "image", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if details.Size != 330 || !details.Created.Equal(time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("details %+v are not correct", details)
+	}
+
+	details, err = manifest.GetDetails(context.Background(), test, "image", "schema1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !details.Created.IsZero() {
+		t.Fatalf("details %+v must not have creation time", details)
+	}
+
+	for _, reference := range []string{"noconfig", "notmanifest", "unknown"} {
+		if _, err := manifest.GetDetails(context.Background(), test, "image", reference); err == nil {
+			t.Fatalf("%s must return error", reference)
+		}
+	}
+}
+
+func TestGetChildren(t *testing.T) {
+	t.Parallel()
+
+	image := `{"schemaVersion": 2, "config": {"digest": "sha256:c1"}}`
+
+	test := fetcher{
+		"amd64":  image,
+		"arm64":  image,
+		"index":  `{"schemaVersion": 2, "manifests": [{"digest": "amd64"}, {"digest": "nested"}]}`,
+		"nested": `{"schemaVersion": 2, "manifests": [{"digest": "arm64"}]}`,
+		"broken": `{"schemaVersion": 2, "manifests": [{"digest": "unknown"}]}`,
+	}
+
+	children, err := manifest.GetChildren(context.Background(), test, "image", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result := strings.Join(children, ","); result != "amd64,nested,arm64" {
+		t.Fatalf("children %s are not correct", result)
+	}
+
+	children, err = manifest.GetChildren(context.Background(), test, "image", "amd64")
+	if err != nil || len(children) != 0 {
+		t.Fatalf("image must not have children %v, %v", children, err)
+	}
+
+	if _, err := manifest.GetChildren(context.Background(), test, "image", "broken"); err == nil {
+		t.Fatal("missing platform manifest must return error")
+	}
+}
@@ -14,21 +14,20 @@ package metrics
 
 import (
 	"context"
-	"flag"
 
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/push"
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	metricsJob     = flag.String("metrics.job", "gitlab_registry_cleaner", "")
-	pushGateWayURL = flag.String("metrics.pushgateway", "", "URL to pushgateway http://localhost:9091")
+const (
+	namespace = "gitlab_registry_cleaner"
+	// buckets of request attempts histogram
+	attemptsBuckets = 10
 )
 
-const namespace = "gitlab_registry_cleaner"
-
 var CompletionTime = prometheus.NewGauge(prometheus.GaugeOpts{
 	Namespace: namespace,
 	Name:      "last_completion_timestamp_seconds",
@@ -41,31 +40,111 @@ var TagsDeleted = prometheus.NewCounter(prometheus.CounterOpts{
 	Help:      "Total deleted tags",
 })
 
+var TagsDeletedByType = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "tags_deleted_by_type_total",
+	Help:      "Total deleted tags by tag type",
+}, []string{"type"})
+
 var TagsWarnings = prometheus.NewCounter(prometheus.CounterOpts{
 	Namespace: namespace,
 	Name:      "tags_warnings_total",
 	Help:      "Total tags with warning",
 })
 
+var TagsSharedDigest = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "tags_shared_digest_total",
+	Help:      "Total tags not deleted because manifest digest is shared with kept tag",
+})
+
+var TagsInUse = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "tags_in_use_total",
+	Help:      "Total tags not deleted because image is used in kubernetes clusters",
+})
+
+var RepositoriesUnmapped = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "repositories_unmapped_total",
+	Help:      "Total repositories not deleted because they are not in any gitlab project",
+})
+
+var RepositoriesMoved = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "repositories_moved_total",
+	Help:      "Total repositories of renamed or transferred gitlab projects",
+})
+
+var UploadsDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "uploads_deleted_total",
+	Help:      "Total deleted stale uploads",
+})
+
+var RepositoriesDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "repositories_deleted_total",
+	Help:      "Total deleted repositories without tags and uploads",
+})
+
+var BlobsDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "blobs_deleted_total",
+	Help:      "Total blobs deleted by garbage collection",
+})
+
+var BlobsReclaimedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "blobs_reclaimed_bytes_total",
+	Help:      "Total bytes of blobs deleted by garbage collection",
+})
+
 var TagsErrors = prometheus.NewCounter(prometheus.CounterOpts{
 	Namespace: namespace,
 	Name:      "tags_errors_total",
 	Help:      "Total tags with error",
 })
 
+var RequestAttempts = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "request_attempts",
+	Help:      "Attempts of requests to gitlab, registry and storage by client",
+	Buckets:   prometheus.LinearBuckets(1, 1, attemptsBuckets),
+}, []string{"client"})
+
+var RequestRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "request_retries_total",
+	Help:      "Total retried requests to gitlab, registry and storage by client and reason",
+}, []string{"client", "reason"})
+
 // Push metrics to pushgateway.
 func Push(ctx context.Context) error {
-	if len(*pushGateWayURL) == 0 {
+	pushGateWayURL := config.Get().Metrics.PushGateway
+
+	if len(pushGateWayURL) == 0 {
 		return nil
 	}
 
-	log.Infof("send metrics to %s", *pushGateWayURL)
+	log.Infof("send metrics to %s", pushGateWayURL)
 
-	if err := push.New(*pushGateWayURL, *metricsJob).
+	if err := push.New(pushGateWayURL, config.Get().Metrics.Job).
 		Collector(CompletionTime).
 		Collector(TagsDeleted).
+		Collector(TagsDeletedByType).
 		Collector(TagsWarnings).
 		Collector(TagsErrors).
+		Collector(TagsSharedDigest).
+		Collector(TagsInUse).
+		Collector(RepositoriesUnmapped).
+		Collector(RepositoriesMoved).
+		Collector(UploadsDeleted).
+		Collector(RepositoriesDeleted).
+		Collector(BlobsDeleted).
+		Collector(BlobsReclaimedBytes).
+		Collector(RequestAttempts).
+		Collector(RequestRetries).
 		PushContext(ctx); err != nil {
 		return errors.Wrap(err, "can not send metrics")
 	}
@@ -0,0 +1,146 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package plan
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/state"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// current plan file format version.
+const Version = 1
+
+type Plan struct {
+	Version   int                    `json:"version"`
+	CreatedAt time.Time              `json:"createdAt"`
+	Provider  string                 `json:"provider"`
+	Delete    []types.DeleteTagInput `json:"delete"`
+	Keep      []types.KeepTagInput   `json:"keep"`
+	// stale uploads of providers that support it
+	Uploads []types.DeleteUploadInput `json:"uploads,omitempty"`
+	// repositories without tags and uploads of providers that support it
+	Repositories []types.DeleteRepositoryInput `json:"repositories,omitempty"`
+	// repositories without gitlab project
+	Unmapped []types.UnmappedRepository `json:"unmapped,omitempty"`
+	// repositories of renamed or transferred gitlab projects
+	Moved []types.MovedRepository `json:"moved,omitempty"`
+	// state of missing projects, it is saved only when plan is run or applied
+	State *state.State `json:"state,omitempty"`
+}
+
+func New(provider string) *Plan {
+	return &Plan{
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+		Provider:  provider,
+		Delete:    make([]types.DeleteTagInput, 0),
+		Keep:      make([]types.KeepTagInput, 0),
+	}
+}
+
+// Sort plan items by repository and tag to make plan reviewable.
+func (p *Plan) Sort() {
+	sort.SliceStable(p.Delete, func(i, j int) bool {
+		if p.Delete[i].Repository != p.Delete[j].Repository {
+			return p.Delete[i].Repository < p.Delete[j].Repository
+		}
+
+		return p.Delete[i].Tag < p.Delete[j].Tag
+	})
+
+	sort.SliceStable(p.Keep, func(i, j int) bool {
+		if p.Keep[i].Repository != p.Keep[j].Repository {
+			return p.Keep[i].Repository < p.Keep[j].Repository
+		}
+
+		return p.Keep[i].Tag < p.Keep[j].Tag
+	})
+
+	sort.SliceStable(p.Uploads, func(i, j int) bool {
+		if p.Uploads[i].Repository != p.Uploads[j].Repository {
+			return p.Uploads[i].Repository < p.Uploads[j].Repository
+		}
+
+		return p.Uploads[i].Upload < p.Uploads[j].Upload
+	})
+
+	sort.SliceStable(p.Repositories, func(i, j int) bool {
+		return p.Repositories[i].Repository < p.Repositories[j].Repository
+	})
+
+	sort.SliceStable(p.Unmapped, func(i, j int) bool {
+		return p.Unmapped[i].Repository < p.Unmapped[j].Repository
+	})
+
+	sort.SliceStable(p.Moved, func(i, j int) bool {
+		return p.Moved[i].Repository < p.Moved[j].Repository
+	})
+}
+
+// Save plan to json file.
+func (p *Plan) Save(path string) error {
+	p.Sort()
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "can not marshal plan")
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil { //nolint:gosec,mnd
+		return errors.Wrap(err, "can not write plan")
+	}
+
+	return nil
+}
+
+// Load plan from json file.
+func Load(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read plan")
+	}
+
+	result := Plan{}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, errors.Wrap(err, "can not parse plan")
+	}
+
+	if result.Version != Version {
+		return nil, errors.Errorf("plan version %d is not supported, need %d", result.Version, Version)
+	}
+
+	for i, tag := range result.Delete {
+		if len(tag.Repository) == 0 || len(tag.Tag) == 0 {
+			return nil, errors.Errorf("delete[%d]: repository and tag must be set", i)
+		}
+
+		if len(tag.Digest) == 0 {
+			return nil, errors.Errorf("delete[%d]: %s:%s has no digest", i, tag.Repository, tag.Tag)
+		}
+	}
+
+	for i, upload := range result.Uploads {
+		if len(upload.Repository) == 0 || len(upload.Upload) == 0 {
+			return nil, errors.Errorf("uploads[%d]: repository and upload must be set", i)
+		}
+	}
+
+	return &result, nil
+}
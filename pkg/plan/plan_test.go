@@ -0,0 +1,116 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package plan_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/plan"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/state"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+)
+
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+
+	result := plan.New("docker")
+	result.Delete = append(result.Delete,
+		types.DeleteTagInput{Repository: "group/project/b", Tag: "feature", TagType: types.BranchStale, Digest: "sha256:2"},
+		types.DeleteTagInput{Repository: "group/project/a", Tag: "feature", TagType: types.BranchStale, Digest: "sha256:1"},
+	)
+	result.Keep = append(result.Keep,
+		types.KeepTagInput{Repository: "group/project/a", Tag: "main", TagType: types.SystemTag, Reason: "system"},
+	)
+
+	result.Uploads = append(result.Uploads,
+		types.DeleteUploadInput{Repository: "group/project/a", Upload: "uuid", StartedAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+	)
+
+	result.Repositories = append(result.Repositories,
+		types.DeleteRepositoryInput{Repository: "group/project/empty", Reason: "empty"},
+	)
+
+	result.Unmapped = append(result.Unmapped,
+		types.UnmappedRepository{Repository: "group/image", Reason: "project group/image not found"},
+	)
+
+	result.State = state.New()
+	result.State.MissingSince["group/removed/image"] = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	result.Moved = append(result.Moved,
+		types.MovedRepository{Repository: "old/project/image", Project: "old/project", MovedTo: "group/project"},
+	)
+
+	if err := result.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := plan.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Delete[0].Repository != "group/project/a" {
+		t.Fatal("plan must be sorted")
+	}
+
+	if !reflect.DeepEqual(loaded.Delete, result.Delete) || !reflect.DeepEqual(loaded.Keep, result.Keep) || !reflect.DeepEqual(loaded.Uploads, result.Uploads) { //nolint:lll
+		t.Fatalf("plan not equals \n(%+v)<=loaded\n(%+v)<=saved", loaded, result)
+	}
+
+	if !reflect.DeepEqual(loaded.Unmapped, result.Unmapped) {
+		t.Fatalf("plan not equals \n(%+v)<=loaded\n(%+v)<=saved", loaded, result)
+	}
+
+	if !reflect.DeepEqual(loaded.Repositories, result.Repositories) {
+		t.Fatalf("plan not equals \n(%+v)<=loaded\n(%+v)<=saved", loaded, result)
+	}
+
+	if !reflect.DeepEqual(loaded.State, result.State) {
+		t.Fatalf("plan not equals \n(%+v)<=loaded\n(%+v)<=saved", loaded, result)
+	}
+
+	if !reflect.DeepEqual(loaded.Moved, result.Moved) {
+		t.Fatalf("plan not equals \n(%+v)<=loaded\n(%+v)<=saved", loaded, result)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []string{
+		`{"version": 999}`,
+		`{"version": 1, "delete": [{"repository": "group/project/a", "tag": "feature"}]}`,
+		`{"version": 1, "delete": [{"repository": "group/project/a", "digest": "sha256:1"}]}`,
+		`{"version": 1, "uploads": [{"repository": "group/project/a"}]}`,
+		`not json`,
+	}
+
+	for i, test := range tests {
+		path := filepath.Join(t.TempDir(), "plan.json")
+
+		if err := os.WriteFile(path, []byte(test), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := plan.Load(path); err == nil {
+			t.Fatalf("test %d must return error", i)
+		}
+	}
+}
@@ -14,33 +14,51 @@ package docker
 
 import (
 	"context"
-	"flag"
+	"io"
 	"net/http"
-	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/heroku/docker-registry-client/registry"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/manifest"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/ratelimit"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/retry"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/utils"
+	godigest "github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	registryWait     = flag.Bool("registry-wait", false, "")
-	registryURL      = flag.String("registry.url", utils.GetEnv("REGISTRY_URL", "http://127.0.0.1:5000"), "format https://registry.com") //nolint:lll
-	registryLogin    = flag.String("registry.username", os.Getenv("REGISTRY_USERNAME"), "")
-	registryPassword = flag.String("registry.password", os.Getenv("REGISTRY_PASSWORD"), "")
-)
-
 const (
 	pingTimeout  = 5 * time.Second
 	waitInterval = 3 * time.Second
 )
 
+var manifestMediaTypes = []string{
+	manifest.MediaTypeOCIIndex,
+	manifest.MediaTypeOCIManifest,
+	manifest.MediaTypeDockerManifestList,
+	manifest.MediaTypeDockerManifest,
+}
+
+// deletion of manifest that is shared by tags, all tags get result of the same request.
+type manifestDeletion struct {
+	once sync.Once
+	err  error
+}
+
 type Provider struct {
 	dryRun bool
 	hub    *registry.Registry
+	// manifests that was deleted in this run, repository@digest
+	deletedDigests      map[string]*manifestDeletion
+	deletedDigestsMutex sync.Mutex
+	// manifests requested by digest are immutable, repository@digest
+	manifests      map[string][]byte
+	manifestsMutex sync.Mutex
 }
 
 func (p *Provider) pingRegistry(ctx context.Context) error {
@@ -48,7 +66,7 @@ func (p *Provider) pingRegistry(ctx context.Context) error {
 		Timeout: pingTimeout,
 	}
 
-	url := utils.FormatURL(*registryURL) + "/v2/"
+	url := utils.FormatURL(config.Get().Docker.URL) + "/v2/"
 
 	log.Infof("waiting for registry %s", url)
 
@@ -74,24 +92,38 @@ func (p *Provider) pingRegistry(ctx context.Context) error {
 // Create new client.
 func (p *Provider) Init(ctx context.Context, dryRun bool) error {
 	p.dryRun = dryRun
+	p.deletedDigests = make(map[string]*manifestDeletion)
+	p.manifests = make(map[string][]byte)
 
-	if *registryWait {
+	registryConfig := config.Get().Docker
+
+	if registryConfig.Wait {
 		for p.pingRegistry(ctx) != nil {
 			time.Sleep(waitInterval)
 		}
 	}
 
-	var err error
+	url := strings.TrimSuffix(registryConfig.URL, "/")
 
-	p.hub, err = registry.New(*registryURL, *registryLogin, *registryPassword)
-	if err != nil {
-		return errors.Wrap(err, "can not connect to registry")
+	// requests are limited and retried under authentication and error handling of registry client
+	transport := &retry.Transport{Base: &ratelimit.Transport{}, Name: "docker"}
+
+	p.hub = &registry.Registry{
+		URL: url,
+		Client: &http.Client{
+			Transport: registry.WrapTransport(transport, url, registryConfig.Username, registryConfig.Password),
+		},
+		Logf: registry.Log,
 	}
 
 	if log.GetLevel() < log.DebugLevel {
 		p.hub.Logf = registry.Quiet
 	}
 
+	if err := p.hub.Ping(); err != nil {
+		return errors.Wrap(err, "can not connect to registry")
+	}
+
 	return nil
 }
 
@@ -116,27 +148,233 @@ func (p *Provider) Tags(_ context.Context, repository string) ([]string, error)
 	return tags, errors.Wrap(err, "can not get tags")
 }
 
-// Delete tag.
-func (p *Provider) DeleteTag(_ context.Context, deleteTag types.DeleteTagInput) error {
-	digest, err := p.hub.ManifestDigest(deleteTag.Repository, deleteTag.Tag)
+// Get manifest digest, tag of index returns digest of index.
+func (p *Provider) Digest(ctx context.Context, repository string, tag string) (string, error) {
+	url := p.hub.URL + "/v2/" + repository + "/manifests/" + tag
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
 	if err != nil {
-		return errors.Wrap(err, "can not get digest")
+		return "", errors.Wrap(err, "error making request")
 	}
 
-	if p.dryRun {
-		log.Warn("nothing to do, dry run")
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
 
-		return nil
+	resp, err := p.hub.Client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "can not get digest")
 	}
 
-	err = p.hub.DeleteManifest(deleteTag.Repository, digest)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("can not get digest %s:%s, status %d", repository, tag, resp.StatusCode)
+	}
+
+	digest, err := godigest.Parse(resp.Header.Get("Docker-Content-Digest"))
 	if err != nil {
-		return errors.Wrapf(err, "can not delete repository manifest %s:%s (%s)", deleteTag.Repository, deleteTag.Tag, digest)
+		return "", errors.Wrap(err, "can not parse digest")
+	}
+
+	return digest.String(), nil
+}
+
+// Get manifest by tag or digest, all manifest media types are accepted.
+func (p *Provider) GetManifest(ctx context.Context, repository, reference string) ([]byte, error) {
+	_, err := godigest.Parse(reference)
+	isDigest := err == nil
+	key := repository + "@" + reference
+
+	if isDigest {
+		p.manifestsMutex.Lock()
+		data, ok := p.manifests[key]
+		p.manifestsMutex.Unlock()
+
+		if ok {
+			return data, nil
+		}
+	}
+
+	url := p.hub.URL + "/v2/" + repository + "/manifests/" + reference
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error making request")
+	}
+
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	data, err := p.read(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if isDigest {
+		p.manifestsMutex.Lock()
+		p.manifests[key] = data
+		p.manifestsMutex.Unlock()
+	}
+
+	return data, nil
+}
+
+// Get blob content.
+func (p *Provider) GetBlob(ctx context.Context, repository, digest string) ([]byte, error) {
+	url := p.hub.URL + "/v2/" + repository + "/blobs/" + digest
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error making request")
+	}
+
+	return p.read(req)
+}
+
+func (p *Provider) read(req *http.Request) ([]byte, error) {
+	resp, err := p.hub.Client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not get %s", req.URL.String())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("can not get %s, status %d", req.URL.String(), resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not read %s", req.URL.String())
+	}
+
+	return data, nil
+}
+
+// Get manifest details.
+func (p *Provider) TagDetails(ctx context.Context, repository string, tag string) (*types.TagDetails, error) {
+	return manifest.GetDetails(ctx, p, repository, tag) //nolint:wrapcheck
+}
+
+// Get platform manifests of index.
+func (p *Provider) Children(ctx context.Context, repository string, digest string) ([]string, error) {
+	return manifest.GetChildren(ctx, p, repository, digest) //nolint:wrapcheck
+}
+
+// Get manifests from OCI referrers API, registry without referrers API has no referrers.
+func (p *Provider) Referrers(ctx context.Context, repository string, digest string) ([]string, error) {
+	url := p.hub.URL + "/v2/" + repository + "/referrers/" + digest
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error making request")
+	}
+
+	req.Header.Set("Accept", manifest.MediaTypeOCIIndex)
+
+	resp, err := p.hub.Client.Do(req)
+	if err != nil {
+		statusError := &registry.HTTPStatusError{}
+		if errors.As(err, &statusError) && statusError.Response.StatusCode == http.StatusNotFound {
+			return []string{}, nil
+		}
+
+		return nil, errors.Wrapf(err, "can not get %s", url)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("can not get %s, status %d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not read %s", url)
+	}
+
+	index, err := manifest.Parse(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not parse referrers")
+	}
+
+	return index.Children(), nil
+}
+
+// Delete tag, platform manifests of index and referrers are deleted after index.
+func (p *Provider) DeleteTag(ctx context.Context, deleteTag types.DeleteTagInput) error {
+	if len(deleteTag.Digest) == 0 {
+		manifestDigest, err := p.Digest(ctx, deleteTag.Repository, deleteTag.Tag)
+		if err != nil {
+			return err
+		}
+
+		deleteTag.Digest = manifestDigest
+	}
+
+	digest, err := godigest.Parse(deleteTag.Digest)
+	if err != nil {
+		return errors.Wrap(err, "can not parse digest")
+	}
+
+	// manifest deletion removes all tags of this manifest
+	if err := p.deleteManifest(deleteTag.Repository, digest); err != nil {
+		return errors.Wrapf(err, "can not delete repository manifest %s:%s", deleteTag.Repository, deleteTag.Tag)
+	}
+
+	// platform manifests and referrers
+	related := append(append([]string{}, deleteTag.Children...), deleteTag.Referrers...)
+
+	for _, item := range related {
+		relatedDigest, err := godigest.Parse(item)
+		if err != nil {
+			return errors.Wrap(err, "can not parse digest")
+		}
+
+		if err := p.deleteManifest(deleteTag.Repository, relatedDigest); err != nil {
+			return errors.Wrapf(err, "can not delete manifest of %s:%s", deleteTag.Repository, deleteTag.Tag)
+		}
 	}
 
 	return nil
 }
 
+func (p *Provider) deleteManifest(repository string, digest godigest.Digest) error {
+	deletedDigest := repository + "@" + digest.String()
+
+	p.deletedDigestsMutex.Lock()
+	deletion, deleted := p.deletedDigests[deletedDigest]
+
+	if !deleted {
+		deletion = &manifestDeletion{}
+		p.deletedDigests[deletedDigest] = deletion
+	}
+
+	p.deletedDigestsMutex.Unlock()
+
+	if deleted {
+		log.Debugf("manifest %s already requested for deletion", deletedDigest)
+	}
+
+	// failed deletion is reported to every tag of manifest
+	deletion.once.Do(func() {
+		if p.dryRun {
+			log.Warnf("nothing to do, dry run, manifest %s", deletedDigest)
+
+			return
+		}
+
+		if err := p.hub.DeleteManifest(repository, digest); err != nil {
+			deletion.err = errors.Wrap(err, digest.String())
+		}
+	})
+
+	return deletion.err
+}
+
+// Manifest deletion removes all tags of manifest.
+func (p *Provider) DeletesManifest() bool {
+	return true
+}
+
 // Final message.
 func (p *Provider) PostCommand(_ context.Context) error {
 	log.Infof("Done")
@@ -0,0 +1,71 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package docker_test
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/providers/docker"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestDeleteTagSharedDigestError(t *testing.T) {
+	deleteRequests := int32(0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/manifests/"+digest) {
+			atomic.AddInt32(&deleteRequests, 1)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	if err := flag.Set("registry.url", server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := flag.Set("retry.max-attempts", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	provider := docker.Provider{}
+
+	if err := provider.Init(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// tags share manifest, failed deletion must be reported for both
+	for _, tag := range []string{"feature", "feature-amd64"} {
+		err := provider.DeleteTag(ctx, types.DeleteTagInput{Repository: "group/project", Tag: tag, Digest: digest})
+		if err == nil {
+			t.Fatalf("%s must return error", tag)
+		}
+	}
+
+	if deleteRequests != 1 {
+		t.Fatalf("manifest must be deleted once, got %d requests", deleteRequests)
+	}
+}
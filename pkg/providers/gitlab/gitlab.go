@@ -0,0 +1,174 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gitlab
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/gitlab"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Provider uses gitlab container registry api, gitlab client must be initialized before.
+type Provider struct {
+	dryRun bool
+	// registry repositories by path
+	repositories map[string]*gitlab.RegistryRepository
+	// repositories are loaded and read by concurrent requests
+	repositoriesMutex sync.Mutex
+}
+
+func (p *Provider) Init(_ context.Context, dryRun bool) error {
+	p.dryRun = dryRun
+
+	return nil
+}
+
+// load registry repositories of configured groups, repositoriesMutex must be locked.
+func (p *Provider) load(ctx context.Context) error {
+	groups := make([]string, 0)
+
+	for _, group := range strings.Split(config.Get().Gitlab.Groups, ",") {
+		if group = strings.TrimSpace(group); len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	repositories, err := gitlab.GetRegistryRepositories(ctx, groups)
+	if err != nil {
+		return errors.Wrap(err, "can not get repositories")
+	}
+
+	p.repositories = make(map[string]*gitlab.RegistryRepository)
+
+	for _, repository := range repositories {
+		p.repositories[repository.Path] = repository
+	}
+
+	return nil
+}
+
+// List repositories.
+func (p *Provider) Repositories(ctx context.Context, filter string) ([]string, error) {
+	p.repositoriesMutex.Lock()
+	defer p.repositoriesMutex.Unlock()
+
+	if err := p.load(ctx); err != nil {
+		return nil, err
+	}
+
+	repos := make([]string, 0, len(p.repositories))
+
+	for repository := range p.repositories {
+		repos = append(repos, repository)
+	}
+
+	sort.Strings(repos)
+
+	if len(filter) > 0 {
+		return utils.FilterStrings(repos, filter), nil
+	}
+
+	return repos, nil
+}
+
+// repositories are loaded on first use, plan can be applied without listing repositories.
+func (p *Provider) getRepository(ctx context.Context, repository string) (*gitlab.RegistryRepository, error) {
+	p.repositoriesMutex.Lock()
+	defer p.repositoriesMutex.Unlock()
+
+	if p.repositories == nil {
+		if err := p.load(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	result, ok := p.repositories[repository]
+	if !ok {
+		return nil, errors.Errorf("repository %s not found", repository)
+	}
+
+	return result, nil
+}
+
+// Get gitlab project path of repository.
+func (p *Provider) Project(ctx context.Context, repository string) (string, error) {
+	registryRepository, err := p.getRepository(ctx, repository)
+	if err != nil {
+		return "", err
+	}
+
+	return registryRepository.ProjectPath, nil
+}
+
+// List tags.
+func (p *Provider) Tags(ctx context.Context, repository string) ([]string, error) {
+	registryRepository, err := p.getRepository(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := gitlab.GetRegistryRepositoryTags(ctx, registryRepository.ProjectID, registryRepository.ID)
+
+	return tags, errors.Wrap(err, "can not get tags")
+}
+
+// Get manifest digest.
+func (p *Provider) Digest(ctx context.Context, repository string, tag string) (string, error) {
+	registryRepository, err := p.getRepository(ctx, repository)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := gitlab.GetRegistryRepositoryTagDigest(ctx, registryRepository.ProjectID, registryRepository.ID, tag)
+	if err != nil {
+		return "", errors.Wrap(err, "can not get digest")
+	}
+
+	return digest, nil
+}
+
+// Delete tag.
+func (p *Provider) DeleteTag(ctx context.Context, deleteTag types.DeleteTagInput) error {
+	registryRepository, err := p.getRepository(ctx, deleteTag.Repository)
+	if err != nil {
+		return err
+	}
+
+	if p.dryRun {
+		log.Warn("nothing to do, dry run")
+
+		return nil
+	}
+
+	err = gitlab.DeleteRegistryRepositoryTag(ctx, registryRepository.ProjectID, registryRepository.ID, deleteTag.Tag)
+	if err != nil {
+		return errors.Wrapf(err, "can not delete tag %s:%s", deleteTag.Repository, deleteTag.Tag)
+	}
+
+	return nil
+}
+
+// Final message, blobs are removed by gitlab registry garbage collection.
+func (p *Provider) PostCommand(_ context.Context) error {
+	log.Infof("Done")
+
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gitlab_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/gitlab"
+	provider "github.com/maksim-paskal/gitlab-registry-cleaner/pkg/providers/gitlab"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+)
+
+// gitlab api with one registry repository group/project, deleted tags are recorded.
+func newGitlabServer(t *testing.T) (*httptest.Server, *sync.Map) {
+	t.Helper()
+
+	deletedTags := &sync.Map{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// lists have one page
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, "[]")
+
+			return
+		}
+
+		switch path := r.URL.EscapedPath(); {
+		case r.Method == http.MethodGet && path == "/api/v4/groups/group/registry/repositories":
+			fmt.Fprint(w, `[{"id":1,"path":"group/project","project_id":2}]`)
+		case r.Method == http.MethodGet && path == "/api/v4/projects/2":
+			fmt.Fprint(w, `{"id":2,"path_with_namespace":"group/project"}`)
+		case r.Method == http.MethodGet && path == "/api/v4/projects/2/registry/repositories/1/tags":
+			fmt.Fprint(w, `[{"name":"main"},{"name":"feature"}]`)
+		case r.Method == http.MethodDelete && strings.HasPrefix(path, "/api/v4/projects/2/registry/repositories/1/tags/"):
+			deletedTags.Store(strings.TrimPrefix(path, "/api/v4/projects/2/registry/repositories/1/tags/"), true)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"404 Not Found"}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	for name, value := range map[string]string{
+		"gitlab.url":         server.URL,
+		"gitlab.groups":      "group",
+		"retry.max-attempts": "1",
+	} {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := gitlab.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return server, deletedTags
+}
+
+func TestTags(t *testing.T) {
+	newGitlabServer(t)
+
+	ctx := context.Background()
+	registry := provider.Provider{}
+
+	if err := registry.Init(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+
+	// repositories are listed and loaded by concurrent requests
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			repositories, err := registry.Repositories(ctx, "")
+			if err != nil || len(repositories) != 1 || repositories[0] != "group/project" {
+				t.Errorf("repositories %v is not correct, %v", repositories, err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			tags, err := registry.Tags(ctx, "group/project")
+			if err != nil || strings.Join(tags, ",") != "main,feature" {
+				t.Errorf("tags %v is not correct, %v", tags, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	project, err := registry.Project(ctx, "group/project")
+	if err != nil || project != "group/project" {
+		t.Fatalf("project %s is not correct, %v", project, err)
+	}
+
+	if _, err := registry.Tags(ctx, "group/other"); err == nil {
+		t.Fatal("unknown repository must return error")
+	}
+}
+
+func TestDeleteTag(t *testing.T) {
+	_, deletedTags := newGitlabServer(t)
+
+	ctx := context.Background()
+
+	for _, dryRun := range []bool{true, false} {
+		registry := provider.Provider{}
+
+		if err := registry.Init(ctx, dryRun); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := registry.DeleteTag(ctx, types.DeleteTagInput{Repository: "group/project", Tag: "feature"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// dry run does not send delete request
+		if _, ok := deletedTags.Load("feature"); ok == dryRun {
+			t.Fatalf("dryRun=%t: tag deletion is not correct", dryRun)
+		}
+	}
+
+	if err := (&provider.Provider{}).DeleteTag(ctx, types.DeleteTagInput{Repository: "group/other", Tag: "main"}); err == nil {
+		t.Fatal("unknown repository must return error")
+	}
+}
@@ -0,0 +1,422 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package layout
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/manifest"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/metrics"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// mark and sweep state, digests are marked for whole bucket and for each repository.
+type garbageCollector struct {
+	mu sync.Mutex
+	// referenced digests of all repositories
+	marked map[string]bool
+	// referenced digests by repository
+	repositories map[string]map[string]bool
+	// references by manifest digest
+	manifests map[string]*references
+	// objects that are modified later are not deleted
+	minAge time.Time
+}
+
+type references struct {
+	blobs    []string
+	children []string
+	// subject of referrer manifest
+	subject string
+}
+
+// path of blob data.
+func blobPath(digest string) (string, error) {
+	parsed, err := godigest.Parse(digest)
+	if err != nil {
+		return "", errors.Wrapf(err, "can not parse digest %s", digest)
+	}
+
+	encoded := parsed.Encoded()
+
+	return config.Get().Storage.BlobsFolder + string(parsed.Algorithm()) + "/" + encoded[:2] + "/" + encoded + "/data", nil
+}
+
+// references of manifest, manifests are read once for all repositories.
+func (p *Provider) manifestReferences(ctx context.Context, gc *garbageCollector, digest string) (*references, error) {
+	gc.mu.Lock()
+	result, ok := gc.manifests[digest]
+	gc.mu.Unlock()
+
+	if ok {
+		return result, nil
+	}
+
+	path, err := blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := p.Store.GetObject(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := manifest.Parse(data)
+	if err != nil {
+		return nil, errors.Wrap(err, digest)
+	}
+
+	result = &references{
+		blobs:    parsed.Blobs(),
+		children: parsed.Children(),
+	}
+
+	if parsed.Subject != nil {
+		result.subject = parsed.Subject.Digest
+	}
+
+	gc.mu.Lock()
+	gc.manifests[digest] = result
+	gc.mu.Unlock()
+
+	return result, nil
+}
+
+// mark manifest, its config, layers and manifests of index.
+func (p *Provider) markManifest(ctx context.Context, gc *garbageCollector, digests map[string]bool, digest string) error {
+	if digests[digest] {
+		return nil
+	}
+
+	digests[digest] = true
+
+	result, err := p.manifestReferences(ctx, gc, digest)
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range result.blobs {
+		digests[blob] = true
+	}
+
+	for _, child := range result.children {
+		if err := p.markManifest(ctx, gc, digests, child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mark all manifests of repository, images can be pulled by digest without tag.
+func (p *Provider) markRevisions(ctx context.Context, gc *garbageCollector, digests map[string]bool, revisions []storage.Object) error { //nolint:lll
+	for _, revision := range revisions {
+		digest := digestFromKey(revision.Key)
+		if len(digest) == 0 {
+			continue
+		}
+
+		if err := p.markManifest(ctx, gc, digests, digest); err != nil {
+			// manifest without data has nothing to keep
+			if errors.Is(err, storage.ErrNotFound) {
+				log.Warnf("%s has no manifest data", revision.Key)
+
+				continue
+			}
+
+			return errors.Wrapf(err, "can not mark %s", revision.Key)
+		}
+	}
+
+	return nil
+}
+
+// mark untagged manifests of repository which subject is marked, signatures and attestations of kept images.
+func (p *Provider) markReferrers(ctx context.Context, gc *garbageCollector, digests map[string]bool, revisions []storage.Object) error { //nolint:lll
+	subjects := make(map[string]string)
+
+	for _, revision := range revisions {
+		digest := digestFromKey(revision.Key)
+		if len(digest) == 0 || digests[digest] {
+			continue
+		}
+
+		result, err := p.manifestReferences(ctx, gc, digest)
+		if err != nil {
+			log.WithError(err).Debugf("can not read revision %s", revision.Key)
+
+			continue
+		}
+
+		if len(result.subject) > 0 {
+			subjects[digest] = result.subject
+		}
+	}
+
+	// referrer can be subject of other referrer
+	for marked := true; marked; {
+		marked = false
+
+		for digest, subject := range subjects {
+			if digests[digest] || !digests[subject] {
+				continue
+			}
+
+			if err := p.markManifest(ctx, gc, digests, digest); err != nil {
+				return err
+			}
+
+			marked = true
+		}
+	}
+
+	return nil
+}
+
+// mark manifests of tags that are not deleted and manifests without tags unless storage.gc.deleteUntagged is set.
+func (p *Provider) markRepository(ctx context.Context, gc *garbageCollector, repository string) error { //nolint:funlen,cyclop
+	repositoryFolder := registryFolder() + repository + "/"
+
+	deleted := p.deletefolders[repositoryFolder]
+
+	digests := make(map[string]bool)
+	// blobs of links that are created during garbage collection are not deleted
+	linked := make(map[string]bool)
+
+	if !deleted {
+		tagFolders, err := p.Store.ListPrefixes(ctx, repositoryFolder+"_manifests/tags/")
+		if err != nil {
+			return err
+		}
+
+		for _, tagFolder := range tagFolders {
+			// tag folder is deleted in this run, in dry run it still exists
+			if p.deletefolders[tagFolder] {
+				continue
+			}
+
+			link, err := p.Store.GetObject(ctx, tagFolder+"current/link")
+			if err != nil {
+				// manifest of tag that is pushed now is not known
+				if errors.Is(err, storage.ErrNotFound) {
+					return errors.Errorf("%s has no current link, tag can be pushed now", tagFolder)
+				}
+
+				return err
+			}
+
+			if err := p.markManifest(ctx, gc, digests, strings.TrimSpace(string(link))); err != nil {
+				return errors.Wrapf(err, "can not mark %s", tagFolder)
+			}
+		}
+
+		revisions, err := p.Store.ListObjects(ctx, repositoryFolder+"_manifests/revisions/")
+		if err != nil {
+			return err
+		}
+
+		layers, err := p.Store.ListObjects(ctx, repositoryFolder+"_layers/")
+		if err != nil {
+			return err
+		}
+
+		// old blob can be linked again by push
+		for _, item := range append(revisions, layers...) {
+			if digest := digestFromKey(item.Key); len(digest) > 0 && item.LastModified.After(gc.minAge) {
+				linked[digest] = true
+			}
+		}
+
+		if config.Get().Storage.GC.DeleteUntagged {
+			if err := p.markReferrers(ctx, gc, digests, revisions); err != nil {
+				return errors.Wrap(err, "can not mark referrers")
+			}
+		} else if err := p.markRevisions(ctx, gc, digests, revisions); err != nil {
+			return errors.Wrap(err, "can not mark revisions")
+		}
+	}
+
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	gc.repositories[repository] = digests
+
+	for digest := range digests {
+		gc.marked[digest] = true
+	}
+
+	for digest := range linked {
+		gc.marked[digest] = true
+	}
+
+	return nil
+}
+
+// mark all repositories concurrently.
+func (p *Provider) mark(ctx context.Context, gc *garbageCollector) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		markErr error
+	)
+
+	workers := make(chan struct{}, config.Get().Storage.ListWorkers)
+
+	for repository := range p.repositories {
+		wg.Add(1)
+
+		go func(repository string) {
+			defer wg.Done()
+
+			workers <- struct{}{}
+			err := p.markRepository(ctx, gc, repository)
+			<-workers
+
+			if err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+
+				if markErr == nil {
+					markErr = errors.Wrap(err, repository)
+
+					cancel()
+				}
+			}
+		}(repository)
+	}
+
+	wg.Wait()
+
+	return markErr
+}
+
+// digest of blob data or link key.
+func digestFromKey(key string) string {
+	parts := strings.Split(key, "/")
+
+	const minParts = 4
+
+	if len(parts) < minParts {
+		return ""
+	}
+
+	switch parts[len(parts)-1] {
+	case "data":
+		// blobs/sha256/<hex prefix>/<hex>/data
+		return parts[len(parts)-4] + ":" + parts[len(parts)-2]
+	case "link":
+		// _layers/sha256/<hex>/link
+		return parts[len(parts)-3] + ":" + parts[len(parts)-2]
+	default:
+		return ""
+	}
+}
+
+// sweep objects that are not marked and older than min age.
+func (p *Provider) sweep(ctx context.Context, gc *garbageCollector, folder string, marked map[string]bool) (int, int64, error) { //nolint:lll
+	objects, err := p.Store.ListObjects(ctx, folder)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	keys := make([]string, 0)
+
+	var size int64
+
+	for _, item := range objects {
+		digest := digestFromKey(item.Key)
+		if len(digest) == 0 || marked[digest] || item.LastModified.After(gc.minAge) {
+			continue
+		}
+
+		log.Debugf("sweep %s", item.Key)
+
+		keys = append(keys, item.Key)
+		size += item.Size
+	}
+
+	if len(keys) > 0 && !p.dryRun {
+		if err := p.Store.DeleteObjects(ctx, keys); err != nil {
+			return 0, 0, errors.Wrap(err, folder)
+		}
+	}
+
+	return len(keys), size, nil
+}
+
+// delete blobs and repository links that are not referenced by tags.
+func (p *Provider) garbageCollect(ctx context.Context) error {
+	gc := &garbageCollector{
+		marked:       make(map[string]bool),
+		repositories: make(map[string]map[string]bool),
+		manifests:    make(map[string]*references),
+		minAge:       time.Now().Add(-config.Get().Storage.GC.MinAge),
+	}
+
+	// all repositories of bucket must be marked, blobs are shared
+	p.repositories = make(map[string]bool)
+
+	if err := p.listRepositories(ctx, registryFolder()); err != nil {
+		return errors.Wrap(err, "failed to list repositories")
+	}
+
+	if err := p.mark(ctx, gc); err != nil {
+		return errors.Wrap(err, "failed to mark")
+	}
+
+	log.Infof("marked %d digests in %d repositories", len(gc.marked), len(gc.repositories))
+
+	links := 0
+
+	for repository, digests := range gc.repositories {
+		repositoryFolder := registryFolder() + repository + "/"
+
+		for _, folder := range []string{"_layers/", "_manifests/revisions/"} {
+			count, _, err := p.sweep(ctx, gc, repositoryFolder+folder, digests)
+			if err != nil {
+				return errors.Wrap(err, "failed to sweep links")
+			}
+
+			links += count
+		}
+	}
+
+	blobs, size, err := p.sweep(ctx, gc, config.Get().Storage.BlobsFolder, gc.marked)
+	if err != nil {
+		return errors.Wrap(err, "failed to sweep blobs")
+	}
+
+	if p.dryRun {
+		log.Warnf("garbage collection dry run, blobs to delete %d (%d bytes), links to delete %d", blobs, size, links)
+
+		return nil
+	}
+
+	metrics.BlobsDeleted.Add(float64(blobs))
+	metrics.BlobsReclaimedBytes.Add(float64(size))
+
+	log.Infof("garbage collection deleted blobs %d (%d bytes), links %d", blobs, size, links)
+
+	return nil
+}
@@ -0,0 +1,328 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package layout
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/manifest"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/utils"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Provider works with registry storage layout in object storage.
+type Provider struct {
+	Store         storage.Store
+	dryRun        bool
+	repositories  map[string]bool
+	deletefolders map[string]bool
+	// tags and uploads can be deleted concurrently
+	deletefoldersMutex sync.Mutex
+	// uploads folder by repository
+	uploadFolders map[string]string
+}
+
+func registryFolder() string {
+	return config.Get().Storage.RegistryFolder
+}
+
+// walk folders concurrently until repository folders are found.
+func (p *Provider) listRepositories(ctx context.Context, folder string) error { //nolint:funlen
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		walkErr error
+		walk    func(prefix string)
+	)
+
+	workers := make(chan struct{}, config.Get().Storage.ListWorkers)
+
+	walk = func(prefix string) {
+		defer wg.Done()
+
+		workers <- struct{}{}
+		prefixes, err := p.Store.ListPrefixes(ctx, prefix)
+		<-workers
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil {
+			if walkErr == nil {
+				walkErr = err
+
+				cancel()
+			}
+
+			return
+		}
+
+		for _, item := range prefixes {
+			if strings.Contains(item, "/_layers/") || strings.Contains(item, "/_manifests/") || strings.Contains(item, "/_uploads/") { //nolint:lll
+				repository := item
+				repository = strings.TrimSuffix(repository, "/_layers/")
+				repository = strings.TrimSuffix(repository, "/_manifests/")
+				repository = strings.TrimSuffix(repository, "/_uploads/")
+				repository = strings.TrimSuffix(repository, "/")
+				repository = strings.TrimPrefix(repository, registryFolder())
+
+				p.repositories[repository] = true
+
+				// uploads are deleted only when they are stale
+				if strings.HasSuffix(item, "/_uploads/") {
+					p.uploadFolders[repository] = item
+				}
+
+				continue
+			}
+
+			wg.Add(1)
+
+			go walk(item)
+		}
+	}
+
+	wg.Add(1)
+
+	go walk(folder)
+
+	wg.Wait()
+
+	return walkErr
+}
+
+func (p *Provider) Init(ctx context.Context, dryRun bool) error {
+	p.dryRun = dryRun
+
+	if err := p.Store.Init(ctx); err != nil {
+		return errors.Wrap(err, "failed to init storage")
+	}
+
+	p.deletefolders = make(map[string]bool)
+	p.uploadFolders = make(map[string]string)
+
+	return nil
+}
+
+func (p *Provider) Repositories(ctx context.Context, filter string) ([]string, error) {
+	p.repositories = make(map[string]bool)
+
+	if err := p.listRepositories(ctx, registryFolder()); err != nil {
+		return nil, errors.Wrap(err, "failed to list objects")
+	}
+
+	repositories := make([]string, 0)
+
+	for repo := range p.repositories {
+		repositories = append(repositories, repo)
+	}
+
+	sort.Strings(repositories)
+
+	if len(filter) > 0 {
+		return utils.FilterStrings(repositories, filter), nil
+	}
+
+	return repositories, nil
+}
+
+func (p *Provider) Tags(ctx context.Context, repository string) ([]string, error) {
+	tagsFolder := fmt.Sprintf("%s%s/_manifests/tags/", registryFolder(), repository)
+
+	prefixes, err := p.Store.ListPrefixes(ctx, tagsFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, 0, len(prefixes))
+
+	for _, item := range prefixes {
+		tag := item
+		tag = strings.TrimPrefix(tag, registryFolder())
+		tag = strings.TrimPrefix(tag, repository)
+		tag = strings.TrimPrefix(tag, "/_manifests/tags/")
+		tag = strings.TrimSuffix(tag, "/")
+
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
+
+// repository has no tags and no uploads, storage is read on every check.
+func (p *Provider) isEmpty(ctx context.Context, repository string) (bool, error) {
+	tags, err := p.Tags(ctx, repository)
+	if err != nil {
+		return false, err
+	}
+
+	if len(tags) > 0 {
+		return false, nil
+	}
+
+	// first push of repository can be in progress
+	uploads, err := p.Store.ListPrefixes(ctx, fmt.Sprintf("%s%s/_uploads/", registryFolder(), repository))
+	if err != nil {
+		return false, err //nolint:wrapcheck
+	}
+
+	return len(uploads) == 0, nil
+}
+
+// List repositories without tags and uploads.
+func (p *Provider) EmptyRepositories(ctx context.Context, repositories []string) ([]types.DeleteRepositoryInput, error) {
+	result := make([]types.DeleteRepositoryInput, 0)
+
+	for _, repository := range repositories {
+		empty, err := p.isEmpty(ctx, repository)
+		if err != nil {
+			return nil, errors.Wrap(err, repository)
+		}
+
+		if !empty {
+			continue
+		}
+
+		log.Debugf("%s no tags and uploads found", repository)
+
+		result = append(result, types.DeleteRepositoryInput{
+			Repository: repository,
+			Reason:     "repository has no tags and uploads",
+		})
+	}
+
+	return result, nil
+}
+
+// Delete repository folder in post command, repository is checked again because tags or uploads
+// can be added after plan.
+func (p *Provider) DeleteRepository(ctx context.Context, repository types.DeleteRepositoryInput) error {
+	if len(repository.Repository) == 0 {
+		return errors.New("invalid repository")
+	}
+
+	empty, err := p.isEmpty(ctx, repository.Repository)
+	if err != nil {
+		return errors.Wrap(err, repository.Repository)
+	}
+
+	if !empty {
+		return errors.Errorf("repository %s has tags or uploads", repository.Repository)
+	}
+
+	p.deleteFolder(fmt.Sprintf("%s%s/", registryFolder(), repository.Repository))
+
+	return nil
+}
+
+func (p *Provider) Digest(ctx context.Context, repository string, tag string) (string, error) {
+	link := fmt.Sprintf("%s%s/_manifests/tags/%s/current/link", registryFolder(), repository, tag)
+
+	digest, err := p.Store.GetObject(ctx, link)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(digest)), nil
+}
+
+// Get manifest by tag or digest.
+func (p *Provider) GetManifest(ctx context.Context, repository, reference string) ([]byte, error) {
+	digest := reference
+
+	if _, err := godigest.Parse(reference); err != nil {
+		digest, err = p.Digest(ctx, repository, reference)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p.GetBlob(ctx, repository, digest)
+}
+
+// Get blob content, blobs are shared by all repositories.
+func (p *Provider) GetBlob(ctx context.Context, _ string, digest string) ([]byte, error) {
+	path, err := blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Store.GetObject(ctx, path) //nolint:wrapcheck
+}
+
+// Get manifest details.
+func (p *Provider) TagDetails(ctx context.Context, repository string, tag string) (*types.TagDetails, error) {
+	return manifest.GetDetails(ctx, p, repository, tag) //nolint:wrapcheck
+}
+
+func (p *Provider) DeleteTag(_ context.Context, deleteTag types.DeleteTagInput) error {
+	if strings.Contains(deleteTag.Tag, "/") {
+		return errors.Errorf("invalid tag %s", deleteTag.Tag)
+	}
+
+	p.deleteFolder(fmt.Sprintf("%s%s/_manifests/tags/%s/", registryFolder(), deleteTag.Repository, deleteTag.Tag))
+
+	return nil
+}
+
+// folder is deleted in post command.
+func (p *Provider) deleteFolder(folder string) {
+	p.deletefoldersMutex.Lock()
+	defer p.deletefoldersMutex.Unlock()
+
+	p.deletefolders[folder] = true
+}
+
+func (p *Provider) PostCommand(ctx context.Context) error {
+	folders := make([]string, 0, len(p.deletefolders))
+
+	for folder := range p.deletefolders {
+		folders = append(folders, folder)
+	}
+
+	sort.Strings(folders)
+
+	for _, folder := range folders {
+		if p.dryRun {
+			log.Warnf("delete folder %s ", folder)
+
+			continue
+		}
+
+		if err := p.Store.DeletePrefix(ctx, folder); err != nil {
+			return errors.Wrapf(err, "failed to delete folder %s", folder)
+		}
+	}
+
+	if config.Get().Storage.GC.Enabled {
+		if err := p.garbageCollect(ctx); err != nil {
+			return errors.Wrap(err, "failed to collect garbage")
+		}
+	}
+
+	log.Infof("Done")
+
+	return nil
+}
@@ -0,0 +1,450 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package layout_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/providers/layout"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/filesystem"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+const (
+	repositoriesFolder = "docker/registry/v2/repositories/"
+	blobsFolder        = "docker/registry/v2/blobs/"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
+
+func newProvider(t *testing.T, root string, dryRun bool) *layout.Provider {
+	t.Helper()
+
+	if err := flag.Set("filesystem.root", root); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &layout.Provider{Store: &filesystem.Store{}}
+
+	if err := provider.Init(context.Background(), dryRun); err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+func newRegistry(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	app := filepath.Join(root, repositoriesFolder, "group/project/app")
+
+	writeFile(t, filepath.Join(app, "_manifests/tags/main/current/link"), "sha256:1\n")
+	writeFile(t, filepath.Join(app, "_manifests/tags/feature/current/link"), "sha256:2\n")
+	writeFile(t, filepath.Join(app, "_layers/sha256/3/link"), "sha256:3")
+	writeFile(t, filepath.Join(app, "_uploads/stale/startedat"), time.Now().Add(-48*time.Hour).Format(time.RFC3339))
+	writeFile(t, filepath.Join(app, "_uploads/fresh/startedat"), time.Now().Format(time.RFC3339))
+	writeFile(t, filepath.Join(root, repositoriesFolder, "group/project/empty/_layers/sha256/3/link"), "sha256:3")
+	writeFile(t, filepath.Join(root, repositoriesFolder, "group/project/pushing/_uploads/first/data"), "data")
+
+	return root
+}
+
+func TestProvider(t *testing.T) { //nolint:funlen,cyclop
+	ctx := context.Background()
+	root := newRegistry(t)
+	provider := newProvider(t, root, false)
+
+	repositories, err := provider.Repositories(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if need := []string{"group/project/app", "group/project/empty", "group/project/pushing"}; !reflect.DeepEqual(repositories, need) { //nolint:lll
+		t.Fatalf("repositories %v need %v", repositories, need)
+	}
+
+	tags, err := provider.Tags(ctx, "group/project/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if need := []string{"feature", "main"}; !reflect.DeepEqual(tags, need) {
+		t.Fatalf("tags %v need %v", tags, need)
+	}
+
+	for _, repository := range []string{"group/project/empty", "group/project/pushing"} {
+		if tags, err := provider.Tags(ctx, repository); err != nil || len(tags) != 0 {
+			t.Fatalf("%s tags %v must be empty, %v", repository, tags, err)
+		}
+	}
+
+	digest, err := provider.Digest(ctx, "group/project/app", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest != "sha256:1" {
+		t.Fatalf("digest %s need sha256:1", digest)
+	}
+
+	if err := provider.DeleteTag(ctx, types.DeleteTagInput{Repository: "group/project/app", Tag: "feature"}); err != nil {
+		t.Fatal(err)
+	}
+
+	uploads, err := provider.StaleUploads(ctx, repositories)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uploads) != 1 || uploads[0].Upload != "stale" {
+		t.Fatalf("uploads %+v must contain only stale upload", uploads)
+	}
+
+	if err := provider.DeleteUpload(ctx, uploads[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	empty, err := provider.EmptyRepositories(ctx, repositories)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(empty) != 1 || empty[0].Repository != "group/project/empty" {
+		t.Fatalf("repositories %+v must contain only empty repository", empty)
+	}
+
+	if err := provider.DeleteRepository(ctx, empty[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.PostCommand(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := []string{
+		"group/project/app/_manifests/tags/feature",
+		"group/project/app/_uploads/stale",
+		"group/project/empty",
+	}
+
+	for _, path := range deleted {
+		if exists(filepath.Join(root, repositoriesFolder, path)) {
+			t.Fatalf("%s must be deleted", path)
+		}
+	}
+
+	kept := []string{
+		"group/project/app/_manifests/tags/main",
+		"group/project/app/_uploads/fresh",
+		// upload without startedat uses modification time
+		"group/project/pushing/_uploads/first",
+	}
+
+	for _, path := range kept {
+		if !exists(filepath.Join(root, repositoriesFolder, path)) {
+			t.Fatalf("%s must be kept", path)
+		}
+	}
+}
+
+func TestDeleteRepository(t *testing.T) {
+	ctx := context.Background()
+	root := newRegistry(t)
+
+	// first push started after plan
+	writeFile(t, filepath.Join(root, repositoriesFolder, "group/project/empty/_uploads/new/data"), "data")
+
+	provider := newProvider(t, root, false)
+
+	for _, repository := range []string{"group/project/app", "group/project/empty"} {
+		if err := provider.DeleteRepository(ctx, types.DeleteRepositoryInput{Repository: repository}); err == nil {
+			t.Fatalf("%s must not be deleted", repository)
+		}
+	}
+
+	if err := provider.PostCommand(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, repository := range []string{"group/project/app", "group/project/empty"} {
+		if !exists(filepath.Join(root, repositoriesFolder, repository)) {
+			t.Fatalf("%s must be kept", repository)
+		}
+	}
+}
+
+func TestProviderDryRun(t *testing.T) {
+	ctx := context.Background()
+	root := newRegistry(t)
+	provider := newProvider(t, root, true)
+
+	if err := provider.DeleteTag(ctx, types.DeleteTagInput{Repository: "group/project/app", Tag: "feature"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.PostCommand(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !exists(filepath.Join(root, repositoriesFolder, "group/project/app/_manifests/tags/feature")) {
+		t.Fatal("tag must not be deleted in dry run")
+	}
+}
+
+func blobPath(root string, digest godigest.Digest) string {
+	return filepath.Join(root, blobsFolder, "sha256", digest.Encoded()[:2], digest.Encoded(), "data")
+}
+
+func imageManifest(config, layer godigest.Digest) string {
+	return fmt.Sprintf(`{"schemaVersion": 2, "config": {"digest": "%s"}, "layers": [{"digest": "%s"}]}`, config, layer)
+}
+
+func referrerManifest(subject, layer godigest.Digest) string {
+	return fmt.Sprintf(`{"schemaVersion": 2, "layers": [{"digest": "%s"}], "subject": {"digest": "%s"}}`, layer, subject)
+}
+
+// registry with image of main tag, image of feature tag and referrers of both images.
+func newGCRegistry(t *testing.T, deleteUntagged bool) (string, map[string]godigest.Digest) { //nolint:funlen
+	t.Helper()
+
+	root := t.TempDir()
+	app := filepath.Join(root, repositoriesFolder, "group/project/app")
+
+	flags := map[string]string{
+		"storage.gc":                 "true",
+		"storage.gc-min-age":         "1h",
+		"storage.gc-delete-untagged": strconv.FormatBool(deleteUntagged),
+	}
+
+	for name, value := range flags {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Cleanup(func() {
+		_ = flag.Set("storage.gc", "false")
+		_ = flag.Set("storage.gc-delete-untagged", "false")
+	})
+
+	digests := make(map[string]godigest.Digest)
+
+	digests["config1"] = godigest.FromString("config1")
+	digests["config2"] = godigest.FromString("config2")
+	digests["shared"] = godigest.FromString("shared")
+	digests["layer2"] = godigest.FromString("layer2")
+	digests["manifest1"] = godigest.FromString(imageManifest(digests["config1"], digests["shared"]))
+	digests["manifest2"] = godigest.FromString(imageManifest(digests["config2"], digests["layer2"]))
+
+	// signature of kept image and signature of its signature are kept, signature of deleted image is deleted
+	digests["signature1"] = godigest.FromString("signature1")
+	digests["signature2"] = godigest.FromString("signature2")
+	digests["referrer1"] = godigest.FromString(referrerManifest(digests["manifest1"], digests["signature1"]))
+	digests["referrer2"] = godigest.FromString(referrerManifest(digests["manifest2"], digests["signature2"]))
+	digests["referrer3"] = godigest.FromString(referrerManifest(digests["referrer1"], digests["signature1"]))
+
+	writeFile(t, blobPath(root, digests["manifest1"]), imageManifest(digests["config1"], digests["shared"]))
+	writeFile(t, blobPath(root, digests["manifest2"]), imageManifest(digests["config2"], digests["layer2"]))
+	writeFile(t, blobPath(root, digests["referrer1"]), referrerManifest(digests["manifest1"], digests["signature1"]))
+	writeFile(t, blobPath(root, digests["referrer2"]), referrerManifest(digests["manifest2"], digests["signature2"]))
+	writeFile(t, blobPath(root, digests["referrer3"]), referrerManifest(digests["referrer1"], digests["signature1"]))
+
+	for _, name := range []string{"config1", "config2", "shared", "layer2", "signature1", "signature2"} {
+		writeFile(t, blobPath(root, digests[name]), "blob")
+		writeFile(t, filepath.Join(app, "_layers/sha256", digests[name].Encoded(), "link"), digests[name].String())
+	}
+
+	for _, name := range []string{"manifest1", "manifest2", "referrer1", "referrer2", "referrer3"} {
+		writeFile(t, filepath.Join(app, "_manifests/revisions/sha256", digests[name].Encoded(), "link"), digests[name].String())
+	}
+
+	writeFile(t, filepath.Join(app, "_manifests/tags/main/current/link"), digests["manifest1"].String())
+	writeFile(t, filepath.Join(app, "_manifests/tags/feature/current/link"), digests["manifest2"].String())
+
+	old := time.Now().Add(-48 * time.Hour)
+
+	err := filepath.Walk(root, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		return os.Chtimes(path, old, old)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blob that is pushed during garbage collection
+	digests["fresh"] = godigest.FromString("fresh")
+	writeFile(t, blobPath(root, digests["fresh"]), "blob")
+
+	return root, digests
+}
+
+// delete feature tag and collect garbage.
+func collectGarbage(t *testing.T, root string) error {
+	t.Helper()
+
+	ctx := context.Background()
+	provider := newProvider(t, root, false)
+
+	if _, err := provider.Repositories(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.DeleteTag(ctx, types.DeleteTagInput{Repository: "group/project/app", Tag: "feature"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return provider.PostCommand(ctx)
+}
+
+func TestGarbageCollect(t *testing.T) {
+	root, digests := newGCRegistry(t, true)
+	app := filepath.Join(root, repositoriesFolder, "group/project/app")
+
+	if err := collectGarbage(t, root); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"manifest1", "config1", "shared", "fresh", "referrer1", "referrer3", "signature1"} {
+		if !exists(blobPath(root, digests[name])) {
+			t.Fatalf("blob %s must be kept", name)
+		}
+	}
+
+	for _, name := range []string{"manifest2", "config2", "layer2", "referrer2", "signature2"} {
+		if exists(blobPath(root, digests[name])) {
+			t.Fatalf("blob %s must be deleted", name)
+		}
+	}
+
+	if exists(filepath.Join(app, "_layers/sha256", digests["layer2"].Encoded(), "link")) {
+		t.Fatal("layer link must be deleted")
+	}
+
+	if exists(filepath.Join(app, "_manifests/revisions/sha256", digests["manifest2"].Encoded(), "link")) {
+		t.Fatal("revision link must be deleted")
+	}
+
+	if !exists(filepath.Join(app, "_layers/sha256", digests["shared"].Encoded(), "link")) {
+		t.Fatal("shared layer link must be kept")
+	}
+}
+
+func TestGarbageCollectUntagged(t *testing.T) {
+	root, digests := newGCRegistry(t, false)
+
+	if err := collectGarbage(t, root); err != nil {
+		t.Fatal(err)
+	}
+
+	// image without tag can be pulled by digest
+	for name, digest := range digests {
+		if !exists(blobPath(root, digest)) {
+			t.Fatalf("blob %s must be kept", name)
+		}
+	}
+}
+
+func TestGarbageCollectNoCurrentLink(t *testing.T) {
+	root, digests := newGCRegistry(t, true)
+
+	// tag is pushed now
+	if err := os.Remove(filepath.Join(root, repositoriesFolder, "group/project/app/_manifests/tags/main/current/link")); err != nil { //nolint:lll
+		t.Fatal(err)
+	}
+
+	if err := collectGarbage(t, root); err == nil {
+		t.Fatal("garbage collection must be aborted")
+	}
+
+	if !exists(blobPath(root, digests["manifest1"])) || !exists(blobPath(root, digests["manifest2"])) {
+		t.Fatal("blobs must not be deleted")
+	}
+}
+
+func TestGarbageCollectLinkedBlob(t *testing.T) {
+	root, digests := newGCRegistry(t, true)
+
+	// old blob of deleted image is linked by new push
+	writeFile(t, filepath.Join(root, repositoriesFolder, "group/project/other/_layers/sha256", digests["layer2"].Encoded(), "link"), digests["layer2"].String()) //nolint:lll
+
+	if err := collectGarbage(t, root); err != nil {
+		t.Fatal(err)
+	}
+
+	if !exists(blobPath(root, digests["layer2"])) {
+		t.Fatal("blob with new link must be kept")
+	}
+
+	if exists(blobPath(root, digests["config2"])) {
+		t.Fatal("blob without links must be deleted")
+	}
+}
+
+func TestTagDetails(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	app := filepath.Join(root, repositoriesFolder, "group/project/app")
+
+	config := godigest.FromString(`{"created": "2023-01-01T00:00:00Z"}`)
+	layer := godigest.FromString("layer")
+	image := fmt.Sprintf(`{"schemaVersion": 2, "config": {"digest": "%s", "size": 10}, "layers": [{"digest": "%s", "size": 100}]}`, config, layer) //nolint:lll
+	manifest := godigest.FromString(image)
+
+	writeFile(t, blobPath(root, config), `{"created": "2023-01-01T00:00:00Z"}`)
+	writeFile(t, blobPath(root, manifest), image)
+	writeFile(t, filepath.Join(app, "_manifests/tags/main/current/link"), manifest.String())
+
+	provider := newProvider(t, root, true)
+
+	details, err := provider.TagDetails(ctx, "group/project/app", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if details.Digest != manifest.String() || details.Size != 110 || details.Created.Year() != 2023 {
+		t.Fatalf("details %+v are not correct", details)
+	}
+
+	if _, err := provider.TagDetails(ctx, "group/project/app", "unknown"); err == nil {
+		t.Fatal("unknown tag must return error")
+	}
+}
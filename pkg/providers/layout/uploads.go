@@ -0,0 +1,114 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package layout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// start time of upload from startedat marker or last modified object of upload.
+func (p *Provider) uploadStartedAt(ctx context.Context, uploadFolder string) (time.Time, error) {
+	startedAt, err := p.Store.GetObject(ctx, uploadFolder+"startedat")
+	if err == nil {
+		result, err := time.Parse(time.RFC3339, strings.TrimSpace(string(startedAt)))
+		if err == nil {
+			return result, nil
+		}
+
+		log.WithError(err).Warnf("%s invalid startedat", uploadFolder)
+	}
+
+	objects, err := p.Store.ListObjects(ctx, uploadFolder)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(objects) == 0 {
+		return time.Time{}, errors.Errorf("%s has no objects", uploadFolder)
+	}
+
+	result := time.Time{}
+
+	for _, item := range objects {
+		if item.LastModified.After(result) {
+			result = item.LastModified
+		}
+	}
+
+	return result, nil
+}
+
+// List uploads of repositories that are started more than max age ago.
+func (p *Provider) StaleUploads(ctx context.Context, repositories []string) ([]types.DeleteUploadInput, error) {
+	maxAge := config.Get().Storage.UploadsMaxAge
+	result := make([]types.DeleteUploadInput, 0)
+
+	for _, repository := range repositories {
+		uploadsFolder, ok := p.uploadFolders[repository]
+		if !ok {
+			continue
+		}
+
+		uploadFolders, err := p.Store.ListPrefixes(ctx, uploadsFolder)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, uploadFolder := range uploadFolders {
+			upload := strings.TrimSuffix(strings.TrimPrefix(uploadFolder, uploadsFolder), "/")
+
+			startedAt, err := p.uploadStartedAt(ctx, uploadFolder)
+			if err != nil {
+				log.WithError(err).Warnf("%s upload %s skipped", repository, upload)
+
+				continue
+			}
+
+			age := time.Since(startedAt).Truncate(time.Second)
+
+			if age < maxAge {
+				log.Infof("keep upload %s/%s age %s, upload is in progress", repository, upload, age)
+
+				continue
+			}
+
+			result = append(result, types.DeleteUploadInput{
+				Repository: repository,
+				Upload:     upload,
+				StartedAt:  startedAt.UTC(),
+				Reason:     fmt.Sprintf("upload age %s is more than %s", age, maxAge),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// Delete upload folder in post command.
+func (p *Provider) DeleteUpload(_ context.Context, upload types.DeleteUploadInput) error {
+	if strings.Contains(upload.Upload, "/") {
+		return errors.Errorf("invalid upload %s", upload.Upload)
+	}
+
+	p.deleteFolder(fmt.Sprintf("%s%s/_uploads/%s/", registryFolder(), upload.Repository, upload.Upload))
+
+	return nil
+}
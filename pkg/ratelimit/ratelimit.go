@@ -0,0 +1,81 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// limiter is shared by all providers and gitlab client, nil when requests are not limited.
+var limiter *rate.Limiter
+
+// Init creates limiter from config, config must be validated before.
+func Init() {
+	limiter = nil
+
+	if requestsPerSecond := config.Get().Registry.RequestsPerSecond; requestsPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+	}
+}
+
+func Enabled() bool {
+	return limiter != nil
+}
+
+// Wait blocks until request is allowed.
+func Wait(ctx context.Context) error {
+	if limiter == nil {
+		return nil
+	}
+
+	if err := limiter.Wait(ctx); err != nil {
+		return errors.Wrap(err, "rate limit")
+	}
+
+	return nil
+}
+
+// Transport waits for shared limiter before every request.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := Wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return base.RoundTrip(req)
+}
+
+// Client returns copy of client with limited transport, client is returned as is when requests are not limited.
+func Client(client *http.Client) *http.Client {
+	if limiter == nil {
+		return client
+	}
+
+	result := *client
+	result.Transport = &Transport{Base: client.Transport}
+
+	return &result
+}
@@ -0,0 +1,69 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ratelimit_test
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/ratelimit"
+)
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	if err := flag.Set("registry.requests-per-second", "20"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = flag.Set("registry.requests-per-second", "0")
+
+		ratelimit.Init()
+	})
+
+	ratelimit.Init()
+
+	client := ratelimit.Client(server.Client())
+	start := time.Now()
+
+	for range 5 {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+	}
+
+	// first request is allowed immediately, next requests wait 50ms
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("5 requests took %s, must be limited to 20 requests per second", elapsed)
+	}
+
+	if err := flag.Set("registry.requests-per-second", "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	ratelimit.Init()
+
+	if ratelimit.Enabled() || ratelimit.Client(http.DefaultClient) != http.DefaultClient {
+		t.Fatal("requests must not be limited")
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package retry
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/metrics"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// backoff shift is limited to not overflow duration.
+const maxBackoffShift = 30
+
+// Transport retries requests on 429, 5xx and network errors with exponential backoff and jitter.
+type Transport struct {
+	Base http.RoundTripper
+	// client name in metrics and logs
+	Name string
+}
+
+// Client returns copy of client with retries.
+func Client(client *http.Client, name string) *http.Client {
+	result := *client
+	result.Transport = &Transport{Base: client.Transport, Name: name}
+
+	return &result
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	maxAttempts := config.Get().Retry.MaxAttempts
+
+	// request body can not be sent again
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptReq, err := rewind(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := base.RoundTrip(attemptReq)
+
+		reason := retryReason(resp, err)
+		if len(reason) == 0 || attempt >= maxAttempts || req.Context().Err() != nil {
+			metrics.RequestAttempts.WithLabelValues(t.Name).Observe(float64(attempt))
+
+			return resp, err //nolint:wrapcheck
+		}
+
+		delay := Delay(attempt, resp)
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		metrics.RequestRetries.WithLabelValues(t.Name, reason).Inc()
+		log.Warnf("%s %s %s failed with %s, attempt %d of %d, retry in %s",
+			t.Name,
+			req.Method,
+			req.URL.Redacted(),
+			reason,
+			attempt,
+			maxAttempts,
+			delay,
+		)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+
+			return nil, errors.Wrap(req.Context().Err(), "retry canceled")
+		case <-timer.C:
+		}
+	}
+}
+
+// request of next attempt with new body.
+func rewind(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 1 || req.GetBody == nil {
+		return req, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, errors.Wrap(err, "can not get request body")
+	}
+
+	result := req.Clone(req.Context())
+	result.Body = body
+
+	return result, nil
+}
+
+// reason of retry, empty if request must not be retried.
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode >= http.StatusInternalServerError && resp.StatusCode != http.StatusNotImplemented) {
+		return strconv.Itoa(resp.StatusCode)
+	}
+
+	return ""
+}
+
+// Delay before next attempt, Retry-After header of response is used if it is set,
+// otherwise exponential backoff with jitter, delay is not greater than max backoff.
+func Delay(attempt int, resp *http.Response) time.Duration {
+	retryConfig := config.Get().Retry
+
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return min(retryAfter, retryConfig.MaxBackoff)
+		}
+	}
+
+	backoff := retryConfig.InitialBackoff << min(attempt-1, maxBackoffShift)
+	if backoff <= 0 || backoff > retryConfig.MaxBackoff {
+		backoff = retryConfig.MaxBackoff
+	}
+
+	// equal jitter, half of delay is random
+	return backoff/2 + rand.N(backoff/2+1) //nolint:gosec
+}
+
+// Retry-After is seconds or http date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,129 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package retry_test
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/retry"
+)
+
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+
+	for name, value := range values {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	setFlags(t, map[string]string{
+		"retry.max-attempts":    "3",
+		"retry.initial-backoff": "1ms",
+		"retry.max-backoff":     "10ms",
+	})
+
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		switch {
+		case r.URL.Path == "/bad":
+			w.WriteHeader(http.StatusBadRequest)
+		case string(body) != "data":
+			w.WriteHeader(http.StatusExpectationFailed)
+		case atomic.AddInt32(&requests, 1) < 3:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	client := retry.Client(server.Client(), "test")
+
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || requests != 3 {
+		t.Fatalf("status %d after %d requests, need 200 after 3 requests", resp.StatusCode, requests)
+	}
+
+	// client errors are not retried
+	resp, err = client.Get(server.URL + "/bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status %d need 400", resp.StatusCode)
+	}
+
+	// last response is returned after all attempts
+	atomic.StoreInt32(&requests, -10)
+
+	resp, err = client.Post(server.URL, "text/plain", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || requests != -7 {
+		t.Fatalf("status %d after %d requests, need 503 after 3 requests", resp.StatusCode, requests+10)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	setFlags(t, map[string]string{
+		"retry.initial-backoff": "100ms",
+		"retry.max-backoff":     "1s",
+	})
+
+	for attempt, limit := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		if delay := retry.Delay(attempt, nil); delay < limit/2 || delay > limit {
+			t.Fatalf("attempt %d delay %s must be between %s and %s", attempt, delay, limit/2, limit)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{}}
+
+	resp.Header.Set("Retry-After", "120")
+
+	if delay := retry.Delay(1, resp); delay != time.Second {
+		t.Fatalf("delay %s must be limited by max backoff", delay)
+	}
+
+	resp.Header.Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+
+	if delay := retry.Delay(1, resp); delay != 0 {
+		t.Fatalf("delay %s of date in past must be 0", delay)
+	}
+}
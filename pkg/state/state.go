@@ -0,0 +1,80 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// current state file format version.
+const Version = 1
+
+// State is kept between runs.
+type State struct {
+	Version int `json:"version"`
+	// first run when project of repository was not found
+	MissingSince map[string]time.Time `json:"missingSince"`
+}
+
+func New() *State {
+	return &State{
+		Version:      Version,
+		MissingSince: make(map[string]time.Time),
+	}
+}
+
+// Save state to json file.
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "can not marshal state")
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil { //nolint:gosec,mnd
+		return errors.Wrap(err, "can not write state")
+	}
+
+	return nil
+}
+
+// Load state from json file, new state is returned on first run.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(), nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read state")
+	}
+
+	result := New()
+
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, errors.Wrap(err, "can not parse state")
+	}
+
+	if result.Version != Version {
+		return nil, errors.Errorf("state version %d is not supported, need %d", result.Version, Version)
+	}
+
+	if result.MissingSince == nil {
+		result.MissingSince = make(map[string]time.Time)
+	}
+
+	return result, nil
+}
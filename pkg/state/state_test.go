@@ -0,0 +1,70 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package state_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/state"
+)
+
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// first run has empty state
+	result, err := state.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.MissingSince) != 0 {
+		t.Fatalf("state %+v must be empty", result)
+	}
+
+	result.MissingSince["group/removed/image"] = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := result.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := state.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded, result) {
+		t.Fatalf("state not equals (%+v)<=loaded (%+v)<=saved", loaded, result)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	for i, test := range []string{`{"version": 999}`, `not json`} {
+		path := filepath.Join(t.TempDir(), "state.json")
+
+		if err := os.WriteFile(path, []byte(test), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := state.Load(path); err == nil {
+			t.Fatalf("test %d must return error", i)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package azure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/ratelimit"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/retry"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// Store works with Azure Blob Storage container.
+type Store struct {
+	client *container.Client
+}
+
+// requests are limited with shared limiter and retried by shared retry layer.
+func clientOptions() *container.ClientOptions {
+	options := &container.ClientOptions{}
+	options.Transport = retry.Client(ratelimit.Client(&http.Client{}), "azure")
+	// sdk retries are disabled, negative value means one try
+	options.Retry.MaxRetries = -1
+
+	return options
+}
+
+// Init uses connection string, shared key or default azure credential.
+func (s *Store) Init(_ context.Context) error {
+	azureConfig := config.Get().Azure
+
+	if len(azureConfig.ConnectionString) > 0 {
+		client, err := container.NewClientFromConnectionString(azureConfig.ConnectionString, azureConfig.Container, clientOptions())
+		if err != nil {
+			return errors.Wrap(err, "failed to create client from connection string")
+		}
+
+		s.client = client
+
+		return nil
+	}
+
+	endpoint := azureConfig.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", azureConfig.AccountName)
+	}
+
+	containerURL := strings.TrimSuffix(endpoint, "/") + "/" + azureConfig.Container
+
+	if len(azureConfig.AccountKey) > 0 {
+		credential, err := container.NewSharedKeyCredential(azureConfig.AccountName, azureConfig.AccountKey)
+		if err != nil {
+			return errors.Wrap(err, "failed to create shared key credential")
+		}
+
+		client, err := container.NewClientWithSharedKeyCredential(containerURL, credential, clientOptions())
+		if err != nil {
+			return errors.Wrap(err, "failed to create client with shared key")
+		}
+
+		s.client = client
+
+		return nil
+	}
+
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to get azure credential")
+	}
+
+	client, err := container.NewClient(containerURL, credential, clientOptions())
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+
+	s.client = client
+
+	return nil
+}
+
+func (s *Store) ListPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	prefixes := make([]string, 0)
+
+	pager := s.client.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+		Prefix: &prefix,
+	})
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list blobs %s", prefix)
+		}
+
+		for _, item := range page.Segment.BlobPrefixes {
+			if item.Name != nil {
+				prefixes = append(prefixes, *item.Name)
+			}
+		}
+	}
+
+	return prefixes, nil
+}
+
+func (s *Store) ListObjects(ctx context.Context, prefix string) ([]storage.Object, error) {
+	objects := make([]storage.Object, 0)
+
+	pager := s.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: &prefix,
+	})
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list blobs %s", prefix)
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+
+			object := storage.Object{Key: *item.Name}
+
+			if item.Properties != nil {
+				if item.Properties.ContentLength != nil {
+					object.Size = *item.Properties.ContentLength
+				}
+
+				if item.Properties.LastModified != nil {
+					object.LastModified = *item.Properties.LastModified
+				}
+			}
+
+			objects = append(objects, object)
+		}
+	}
+
+	return objects, nil
+}
+
+func (s *Store) GetObject(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.client.NewBlobClient(key).DownloadStream(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, errors.Wrap(storage.ErrNotFound, key)
+		}
+
+		return nil, errors.Wrapf(err, "failed to download blob %s", key)
+	}
+
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read blob %s", key)
+	}
+
+	return data, nil
+}
+
+func (s *Store) DeleteObjects(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if _, err := s.client.NewBlobClient(key).Delete(ctx, nil); err != nil {
+			if bloberror.HasCode(err, bloberror.BlobNotFound) {
+				continue
+			}
+
+			return errors.Wrapf(err, "failed to delete blob %s", key)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) DeletePrefix(ctx context.Context, prefix string) error {
+	objects, err := s.ListObjects(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(objects))
+
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+
+	return s.DeleteObjects(ctx, keys)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package azure_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/azure"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/storagetest"
+)
+
+// Test with Azurite, AZURITE_CONNECTION_STRING must be set.
+func TestStore(t *testing.T) {
+	connectionString := os.Getenv("AZURITE_CONNECTION_STRING")
+	if len(connectionString) == 0 {
+		t.Skip("AZURITE_CONNECTION_STRING is not set")
+	}
+
+	ctx := context.Background()
+	containerName := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	client, err := container.NewClientFromConnectionString(connectionString, containerName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Delete(ctx, nil) //nolint:errcheck
+
+	if err := flag.Set("azure.connection-string", connectionString); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := flag.Set("azure.container", containerName); err != nil {
+		t.Fatal(err)
+	}
+
+	store := azure.Store{}
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	storagetest.Run(t, &store, func(key, data string) {
+		if _, err := client.NewBlockBlobClient(key).UploadBuffer(ctx, []byte(data), nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// well known key of Azurite.
+const azuriteKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+func TestRetry(t *testing.T) {
+	requests := int32(0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	flags := map[string]string{
+		"azure.connection-string": fmt.Sprintf("DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;AccountKey=%s;BlobEndpoint=%s/devstoreaccount1;", azuriteKey, server.URL), //nolint:lll
+		"azure.container":         "registry",
+		"retry.max-attempts":      "3",
+		"retry.initial-backoff":   "1ms",
+		"retry.max-backoff":       "1ms",
+	}
+
+	for name, value := range flags {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	store := azure.Store{}
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.ListPrefixes(ctx, "docker/"); err == nil {
+		t.Fatal("failed request must return error")
+	}
+
+	// requests are retried only by shared retry layer
+	if requests != 3 {
+		t.Fatalf("request must be sent 3 times, got %d", requests)
+	}
+}
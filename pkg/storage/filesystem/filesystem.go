@@ -0,0 +1,164 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package filesystem
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// Store works with registry storage root on local disk, keys are relative to root.
+type Store struct {
+	root string
+}
+
+func (s *Store) Init(_ context.Context) error {
+	s.root = config.Get().Filesystem.Root
+
+	if _, err := os.Stat(s.root); err != nil {
+		return errors.Wrap(err, "failed to open storage root")
+	}
+
+	return nil
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *Store) ListPrefixes(_ context.Context, prefix string) ([]string, error) {
+	folder, name := path.Split(prefix)
+
+	entries, err := os.ReadDir(s.path(folder))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+
+		return nil, errors.Wrapf(err, "failed to read folder %s", folder)
+	}
+
+	prefixes := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), name) {
+			prefixes = append(prefixes, folder+entry.Name()+"/")
+		}
+	}
+
+	return prefixes, nil
+}
+
+func (s *Store) ListObjects(_ context.Context, prefix string) ([]storage.Object, error) {
+	folder, _ := path.Split(prefix)
+	objects := make([]storage.Object, 0)
+
+	err := filepath.WalkDir(s.path(folder), func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		key, err := filepath.Rel(s.root, file)
+		if err != nil {
+			return errors.Wrap(err, "failed to get key")
+		}
+
+		key = filepath.ToSlash(key)
+
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return errors.Wrap(err, "failed to get file info")
+		}
+
+		objects = append(objects, storage.Object{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Wrapf(err, "failed to walk %s", folder)
+	}
+
+	return objects, nil
+}
+
+func (s *Store) GetObject(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Wrap(storage.ErrNotFound, key)
+		}
+
+		return nil, errors.Wrapf(err, "failed to read %s", key)
+	}
+
+	return data, nil
+}
+
+// Delete objects and their folders if folders become empty.
+func (s *Store) DeleteObjects(_ context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrapf(err, "failed to delete %s", key)
+		}
+
+		// folder of blob or link, fails when folder is not empty
+		if folder := path.Dir(key); folder != "." {
+			_ = os.Remove(s.path(folder))
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) DeletePrefix(ctx context.Context, prefix string) error {
+	if !strings.HasSuffix(prefix, "/") {
+		objects, err := s.ListObjects(ctx, prefix)
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(objects))
+
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+
+		return s.DeleteObjects(ctx, keys)
+	}
+
+	if err := os.RemoveAll(s.path(prefix)); err != nil {
+		return errors.Wrapf(err, "failed to delete folder %s", prefix)
+	}
+
+	return nil
+}
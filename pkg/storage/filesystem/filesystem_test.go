@@ -0,0 +1,50 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package filesystem_test
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/filesystem"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/storagetest"
+)
+
+func TestStore(t *testing.T) {
+	root := t.TempDir()
+
+	if err := flag.Set("filesystem.root", root); err != nil {
+		t.Fatal(err)
+	}
+
+	store := filesystem.Store{}
+
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	storagetest.Run(t, &store, func(key, data string) {
+		path := filepath.Join(root, filepath.FromSlash(key))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,235 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/ratelimit"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/retry"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	defaultEndpoint = "https://storage.googleapis.com"
+	scope           = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// Store works with Google Cloud Storage bucket with JSON API.
+type Store struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+}
+
+type listResponse struct {
+	Items []struct {
+		Name    string    `json:"name"`
+		Size    string    `json:"size"`
+		Updated time.Time `json:"updated"`
+	} `json:"items"`
+	Prefixes      []string `json:"prefixes"`
+	NextPageToken string   `json:"nextPageToken"`
+}
+
+// Init uses application default credentials, without credentials when endpoint of emulator is set.
+func (s *Store) Init(ctx context.Context) error {
+	gcsConfig := config.Get().GCS
+
+	s.bucket = gcsConfig.Bucket
+	s.endpoint = defaultEndpoint
+	s.client = retry.Client(ratelimit.Client(http.DefaultClient), "gcs")
+
+	if len(gcsConfig.Endpoint) > 0 {
+		s.endpoint = strings.TrimSuffix(gcsConfig.Endpoint, "/")
+
+		return nil
+	}
+
+	client, err := google.DefaultClient(ctx, scope)
+	if err != nil {
+		return errors.Wrap(err, "failed to get google credentials")
+	}
+
+	s.client = retry.Client(ratelimit.Client(client), "gcs")
+
+	return nil
+}
+
+func (s *Store) objectURL(key string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", s.endpoint, url.PathEscape(s.bucket), url.PathEscape(key))
+}
+
+func (s *Store) do(ctx context.Context, method, requestURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to %s %s", method, requestURL)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, storage.ErrNotFound
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+
+		return nil, errors.Errorf("%s %s returned %d: %s", method, requestURL, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
+
+// list all pages of objects with prefix.
+func (s *Store) list(ctx context.Context, prefix string, delimiter string, page func(*listResponse) error) error {
+	query := url.Values{}
+	query.Set("prefix", prefix)
+
+	if len(delimiter) > 0 {
+		query.Set("delimiter", delimiter)
+	}
+
+	for {
+		requestURL := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", s.endpoint, url.PathEscape(s.bucket), query.Encode())
+
+		resp, err := s.do(ctx, http.MethodGet, requestURL)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list objects %s", prefix)
+		}
+
+		result := listResponse{}
+
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode objects %s", prefix)
+		}
+
+		if err := page(&result); err != nil {
+			return err
+		}
+
+		if len(result.NextPageToken) == 0 {
+			return nil
+		}
+
+		query.Set("pageToken", result.NextPageToken)
+	}
+}
+
+func (s *Store) ListPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	prefixes := make([]string, 0)
+
+	err := s.list(ctx, prefix, "/", func(page *listResponse) error {
+		prefixes = append(prefixes, page.Prefixes...)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return prefixes, nil
+}
+
+func (s *Store) ListObjects(ctx context.Context, prefix string) ([]storage.Object, error) {
+	objects := make([]storage.Object, 0)
+
+	err := s.list(ctx, prefix, "", func(page *listResponse) error {
+		for _, item := range page.Items {
+			size, err := strconv.ParseInt(item.Size, 10, 64)
+			if err != nil {
+				return errors.Wrapf(err, "invalid size of %s", item.Name)
+			}
+
+			objects = append(objects, storage.Object{
+				Key:          item.Name,
+				Size:         size,
+				LastModified: item.Updated,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+func (s *Store) GetObject(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key)+"?alt=media")
+	if err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read object %s", key)
+	}
+
+	return data, nil
+}
+
+func (s *Store) DeleteObjects(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key))
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+
+			return errors.Wrapf(err, "failed to delete object %s", key)
+		}
+
+		resp.Body.Close()
+	}
+
+	return nil
+}
+
+func (s *Store) DeletePrefix(ctx context.Context, prefix string) error {
+	objects, err := s.ListObjects(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(objects))
+
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+
+	return s.DeleteObjects(ctx, keys)
+}
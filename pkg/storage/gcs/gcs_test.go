@@ -0,0 +1,159 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gcs_test
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/gcs"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage/storagetest"
+)
+
+const listPageSize = 2
+
+// fake of JSON API, fake-gcs-server can be used with -gcs.endpoint.
+type fakeServer struct {
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+func (s *fakeServer) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+	offset, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+
+	names := make([]string, 0)
+	prefixes := make(map[string]bool)
+
+	for name := range s.objects {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		if i := strings.Index(name[len(prefix):], delimiter); len(delimiter) > 0 && i >= 0 {
+			prefixes[name[:len(prefix)+i+1]] = true
+
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	result := map[string]interface{}{}
+	items := make([]map[string]string, 0)
+
+	for i := offset; i < len(names) && i < offset+listPageSize; i++ {
+		items = append(items, map[string]string{
+			"name":    names[i],
+			"size":    strconv.Itoa(len(s.objects[names[i]])),
+			"updated": time.Now().Format(time.RFC3339),
+		})
+	}
+
+	result["items"] = items
+
+	if offset+listPageSize < len(names) {
+		result["nextPageToken"] = strconv.Itoa(offset + listPageSize)
+	}
+
+	if offset == 0 {
+		result["prefixes"] = sortedKeys(prefixes)
+	}
+
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func sortedKeys(values map[string]bool) []string {
+	result := make([]string, 0, len(values))
+
+	for value := range values {
+		result = append(result, value)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/storage/v1/b/registry/o" {
+		s.list(w, r)
+
+		return
+	}
+
+	name, ok := strings.CutPrefix(r.URL.Path, "/storage/v1/b/registry/o/")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	data, ok := s.objects[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		_, _ = w.Write([]byte(data))
+	case http.MethodDelete:
+		delete(s.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestStore(t *testing.T) {
+	server := &fakeServer{objects: make(map[string]string)}
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	if err := flag.Set("gcs.endpoint", ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := flag.Set("gcs.bucket", "registry"); err != nil {
+		t.Fatal(err)
+	}
+
+	store := gcs.Store{}
+
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	storagetest.Run(t, &store, func(key, data string) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		server.objects[key] = data
+	})
+}
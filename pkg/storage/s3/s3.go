@@ -0,0 +1,182 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package s3
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/config"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/ratelimit"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/retry"
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+const listMax = 1000
+
+// Store works with AWS S3 or S3 compatible bucket.
+type Store struct {
+	svc    *s3.S3
+	bucket string
+}
+
+func (s *Store) Init(_ context.Context) error {
+	s3Config := config.Get().S3
+	awsConfig := aws.Config{}
+
+	if len(s3Config.AccessKey) > 0 && len(s3Config.SecretKey) > 0 {
+		awsConfig.Credentials = credentials.NewStaticCredentials(s3Config.AccessKey, s3Config.SecretKey, "")
+	}
+
+	if len(s3Config.Region) > 0 {
+		awsConfig.Region = aws.String(s3Config.Region)
+	}
+
+	if len(s3Config.Endpoint) > 0 {
+		awsConfig.Endpoint = aws.String(s3Config.Endpoint)
+	}
+
+	if s3Config.DisableSSL {
+		awsConfig.DisableSSL = aws.Bool(true)
+	}
+
+	if s3Config.ForcePathStyle {
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	// requests are retried by shared retry layer
+	awsConfig.MaxRetries = aws.Int(0)
+	awsConfig.HTTPClient = retry.Client(ratelimit.Client(&http.Client{}), "s3")
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	s.svc = s3.New(sess, &awsConfig)
+	s.bucket = s3Config.Bucket
+
+	return nil
+}
+
+func (s *Store) ListPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	prefixes := make([]string, 0)
+
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Delimiter: aws.String("/"),
+		Prefix:    aws.String(prefix),
+		MaxKeys:   aws.Int64(listMax),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, item := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.StringValue(item.Prefix))
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list objects %s", prefix)
+	}
+
+	return prefixes, nil
+}
+
+func (s *Store) ListObjects(ctx context.Context, prefix string) ([]storage.Object, error) {
+	objects := make([]storage.Object, 0)
+
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(listMax),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, item := range page.Contents {
+			objects = append(objects, storage.Object{
+				Key:          aws.StringValue(item.Key),
+				Size:         aws.Int64Value(item.Size),
+				LastModified: aws.TimeValue(item.LastModified),
+			})
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list objects %s", prefix)
+	}
+
+	return objects, nil
+}
+
+func (s *Store) GetObject(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey { //nolint:errorlint
+			return nil, errors.Wrap(storage.ErrNotFound, key)
+		}
+
+		return nil, errors.Wrapf(err, "failed to get object %s", key)
+	}
+
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read object %s", key)
+	}
+
+	return data, nil
+}
+
+func (s *Store) DeleteObjects(ctx context.Context, keys []string) error {
+	objects := make([]s3manager.BatchDeleteObject, 0, len(keys))
+
+	for _, key := range keys {
+		objects = append(objects, s3manager.BatchDeleteObject{
+			Object: &s3.DeleteObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    aws.String(key),
+			},
+		})
+	}
+
+	iter := &s3manager.DeleteObjectsIterator{Objects: objects}
+
+	if err := s3manager.NewBatchDeleteWithClient(s.svc).Delete(ctx, iter); err != nil {
+		return errors.Wrap(err, "failed to delete objects")
+	}
+
+	return nil
+}
+
+func (s *Store) DeletePrefix(ctx context.Context, prefix string) error {
+	iter := s3manager.NewDeleteListIterator(s.svc, &s3.ListObjectsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	if err := s3manager.NewBatchDeleteWithClient(s.svc).Delete(ctx, iter); err != nil {
+		return errors.Wrap(err, "failed to delete files under given directory")
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by GetObject when object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// Object in storage, key is slash separated path.
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Store is object storage with registry storage layout.
+type Store interface {
+	Init(ctx context.Context) error
+	// list common prefixes of prefix with "/" delimiter, prefixes end with "/"
+	ListPrefixes(ctx context.Context, prefix string) ([]string, error)
+	// list all objects with prefix recursively
+	ListObjects(ctx context.Context, prefix string) ([]Object, error)
+	GetObject(ctx context.Context, key string) ([]byte, error)
+	// delete objects, objects that not exist are ignored
+	DeleteObjects(ctx context.Context, keys []string) error
+	// delete all objects with prefix
+	DeletePrefix(ctx context.Context, prefix string) error
+}
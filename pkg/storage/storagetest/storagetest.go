@@ -0,0 +1,124 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package storagetest
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+func keys(objects []storage.Object) []string {
+	result := make([]string, 0, len(objects))
+
+	for _, object := range objects {
+		result = append(result, object.Key)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// Run checks store behavior, put must create object in initialized store.
+func Run(t *testing.T, store storage.Store, put func(key, data string)) { //nolint:funlen,cyclop
+	t.Helper()
+
+	ctx := context.Background()
+
+	put("root/a/b/1", "1")
+	put("root/a/c/2", "22")
+	put("root/d", "333")
+	put("other/x", "x")
+
+	prefixes, err := store.ListPrefixes(ctx, "root/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if need := []string{"root/a/"}; !reflect.DeepEqual(prefixes, need) {
+		t.Fatalf("prefixes %v need %v", prefixes, need)
+	}
+
+	prefixes, err = store.ListPrefixes(ctx, "root/a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(prefixes)
+
+	if need := []string{"root/a/b/", "root/a/c/"}; !reflect.DeepEqual(prefixes, need) {
+		t.Fatalf("prefixes %v need %v", prefixes, need)
+	}
+
+	if prefixes, err := store.ListPrefixes(ctx, "missing/"); err != nil || len(prefixes) != 0 {
+		t.Fatalf("prefixes %v of missing folder must be empty, %v", prefixes, err)
+	}
+
+	objects, err := store.ListObjects(ctx, "root/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if need := []string{"root/a/b/1", "root/a/c/2", "root/d"}; !reflect.DeepEqual(keys(objects), need) {
+		t.Fatalf("objects %v need %v", keys(objects), need)
+	}
+
+	for _, object := range objects {
+		if object.Key == "root/d" && object.Size != 3 {
+			t.Fatalf("object %+v size must be 3", object)
+		}
+
+		if object.LastModified.IsZero() {
+			t.Fatalf("object %+v must have last modified", object)
+		}
+	}
+
+	data, err := store.GetObject(ctx, "root/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "333" {
+		t.Fatalf("data %s need 333", string(data))
+	}
+
+	if _, err := store.GetObject(ctx, "root/missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("error %v must be not found", err)
+	}
+
+	if err := store.DeleteObjects(ctx, []string{"root/a/b/1", "root/missing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetObject(ctx, "root/a/b/1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("error %v must be not found", err)
+	}
+
+	if err := store.DeletePrefix(ctx, "root/a/"); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err = store.ListObjects(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if need := []string{"other/x", "root/d"}; !reflect.DeepEqual(keys(objects), need) {
+		t.Fatalf("objects %v need %v", keys(objects), need)
+	}
+}
@@ -12,7 +12,10 @@ limitations under the License.
 */
 package types
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type TagType string
 
@@ -30,12 +33,90 @@ const (
 	BranchNotStaled         TagType = "BranchNotStaled"
 	SnapshotTagCanNotDelete TagType = "SnapshotTagCanNotDelete"
 	SnapshotStaled          TagType = "SnapshotStaled"
+	SharedDigest            TagType = "SharedDigest"
+	BranchOpenMergeRequest  TagType = "BranchOpenMergeRequest"
+	DeployedToEnvironment   TagType = "DeployedToEnvironment"
+	InUse                   TagType = "InUse"
+	UnknownExpired          TagType = "UnknownExpired"
+	Referrer                TagType = "Referrer"
+	ReferrerSubjectDeleted  TagType = "ReferrerSubjectDeleted"
+	ReferrerSubjectNotFound TagType = "ReferrerSubjectNotFound"
+	ProjectArchived         TagType = "ProjectArchived"
+	ProjectMissing          TagType = "ProjectMissing"
+	DigestChanged           TagType = "DigestChanged"
 )
 
 type DeleteTagInput struct {
-	Repository string
-	Tag        string
-	TagType    TagType
+	Repository string  `json:"repository"`
+	Tag        string  `json:"tag"`
+	TagType    TagType `json:"tagType"`
+	Reason     string  `json:"reason,omitempty"`
+	// manifest digest of tag
+	Digest string `json:"digest,omitempty"`
+	// manifest details, set with -tag.details
+	MediaType string     `json:"mediaType,omitempty"`
+	Created   *time.Time `json:"created,omitempty"`
+	Size      int64      `json:"size,omitempty"`
+	// manifests of index that are not used by kept tags, deleted together with index
+	Children []string `json:"children,omitempty"`
+	// manifests from referrers API (signatures, attestations) of manifest and its children
+	Referrers []string `json:"referrers,omitempty"`
+}
+
+// Upload that is older than max age.
+type DeleteUploadInput struct {
+	Repository string `json:"repository"`
+	// upload uuid
+	Upload    string    `json:"upload"`
+	StartedAt time.Time `json:"startedAt"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// Repository without tags and uploads, its folder is deleted.
+type DeleteRepositoryInput struct {
+	Repository string `json:"repository"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// Repository that is not in any gitlab project, its tags are not deleted.
+type UnmappedRepository struct {
+	Repository string `json:"repository"`
+	Reason     string `json:"reason"`
+	// project paths that were not found
+	Projects []string `json:"projects,omitempty"`
+	// first run when project was not found, set with projects.missing.enabled
+	MissingSince *time.Time `json:"missingSince,omitempty"`
+}
+
+// Repository of gitlab project that was renamed or transferred to another group.
+type MovedRepository struct {
+	Repository string `json:"repository"`
+	// project path of repository
+	Project string `json:"project"`
+	// current project path
+	MovedTo string `json:"movedTo"`
+}
+
+// Manifest details of tag.
+type TagDetails struct {
+	Digest    string
+	MediaType string
+	// creation time from image config, newest image for index
+	Created time.Time
+	// compressed size of config and layers, sum of images for index
+	Size int64
+}
+
+type KeepTagInput struct {
+	Repository string  `json:"repository"`
+	Tag        string  `json:"tag"`
+	TagType    TagType `json:"tagType"`
+	Reason     string  `json:"reason,omitempty"`
+	Digest     string  `json:"digest,omitempty"`
+	// manifest details, set with -tag.details
+	MediaType string     `json:"mediaType,omitempty"`
+	Created   *time.Time `json:"created,omitempty"`
+	Size      int64      `json:"size,omitempty"`
 }
 type Provider interface {
 	// Initialize provider
@@ -44,8 +125,128 @@ type Provider interface {
 	Repositories(ctx context.Context, filter string) ([]string, error)
 	// List tags in provider
 	Tags(ctx context.Context, repository string) ([]string, error)
+	// Get manifest digest of tag
+	Digest(ctx context.Context, repository string, tag string) (string, error)
 	// Delete tag
 	DeleteTag(ctx context.Context, deleteTag DeleteTagInput) error
 	// Run post commands in provider
 	PostCommand(ctx context.Context) error
 }
+
+// Optional provider interface, provider can delete stale uploads.
+type UploadsProvider interface {
+	// List uploads of repositories that are older than max age
+	StaleUploads(ctx context.Context, repositories []string) ([]DeleteUploadInput, error)
+	// Delete upload
+	DeleteUpload(ctx context.Context, upload DeleteUploadInput) error
+}
+
+// Optional provider interface, provider can delete repositories without tags.
+type RepositoriesProvider interface {
+	// List repositories without tags and uploads
+	EmptyRepositories(ctx context.Context, repositories []string) ([]DeleteRepositoryInput, error)
+	// Delete repository, repository that has tags or uploads now is not deleted
+	DeleteRepository(ctx context.Context, repository DeleteRepositoryInput) error
+}
+
+// Optional provider interface, provider can read manifest details of tag.
+type DetailsProvider interface {
+	// Get digest, media type, creation time and size of tag
+	TagDetails(ctx context.Context, repository string, tag string) (*TagDetails, error)
+}
+
+// Optional provider interface, provider can read manifests of index.
+type IndexProvider interface {
+	// Get digests of manifests of index recursively, empty for image manifest
+	Children(ctx context.Context, repository string, digest string) ([]string, error)
+}
+
+// Optional provider interface, provider supports OCI referrers API.
+type ReferrersProvider interface {
+	// Get digests of manifests which subject is digest
+	Referrers(ctx context.Context, repository string, digest string) ([]string, error)
+}
+
+// Optional provider interface, provider deletes tag by deleting its manifest.
+type ManifestProvider interface {
+	// Deletion of tag deletes all tags that point to the same manifest
+	DeletesManifest() bool
+}
+
+// Optional provider interface, provider knows gitlab project of repository.
+type ProjectProvider interface {
+	// Get gitlab project path of repository, empty path is resolved from repository path
+	Project(ctx context.Context, repository string) (string, error)
+}
+
+const hoursInDay = 24
+
+type Branch struct {
+	Name           string    `json:"name"`
+	LastCommitDate time.Time `json:"lastCommitDate"`
+}
+
+// Branch is staled if last commit was more than staleDays before now.
+func (b *Branch) IsStaled(now time.Time, staleDays int) bool {
+	return now.Sub(b.LastCommitDate).Hours() > float64(hoursInDay*staleDays)
+}
+
+type MergeRequest struct {
+	IID          int    `json:"iid"`
+	SourceBranch string `json:"sourceBranch"`
+	// merge request from fork, image is built in target project
+	Fork bool `json:"fork,omitempty"`
+}
+
+// Last successful deployment to available environment.
+type Deployment struct {
+	Environment string `json:"environment"`
+	Ref         string `json:"ref"`
+	SHA         string `json:"sha"`
+}
+
+// Gitlab project of repository.
+type GitlabProject struct {
+	ID int
+	// path with namespace, differs from requested path when project was moved
+	Path     string
+	Archived bool
+}
+
+// Gitlab project information for tag classification.
+type ProjectContext struct {
+	Path string
+	ID   int
+	// time of plan, branches are staled relative to it
+	Now time.Time
+	// gitlab branches by slug name
+	Branches map[string]*Branch
+	// open merge requests by source branch slug name
+	OpenMergeRequests map[string]*MergeRequest
+	// deployments by ref slug name, commit sha and short commit sha
+	Deployments map[string][]*Deployment
+	// branch is staled if last commit more than this days ago
+	BranchStaleDays int
+	// release tags that must be kept by retention policy
+	ReleaseTagsNotToDelete map[string]bool
+}
+
+type ClassifierInput struct {
+	Repository string
+	Tag        string
+	Project    *ProjectContext
+}
+
+type ClassifierResult struct {
+	TagType TagType
+	// human readable reason of decision
+	Reason string
+	Delete bool
+}
+
+type Classifier interface {
+	// Name of classifier
+	Name() string
+	// Classify tag, returns nil if classifier has no decision for tag
+	Classify(input *ClassifierInput) *ClassifierResult
+}
@@ -14,6 +14,7 @@ package types_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/types"
 )
@@ -32,6 +33,17 @@ func TestTagType(t *testing.T) {
 	tests[types.BranchNotStaled] = "BranchNotStaled"
 	tests[types.SnapshotTagCanNotDelete] = "SnapshotTagCanNotDelete"
 	tests[types.SnapshotStaled] = "SnapshotStaled"
+	tests[types.SharedDigest] = "SharedDigest"
+	tests[types.BranchOpenMergeRequest] = "BranchOpenMergeRequest"
+	tests[types.DeployedToEnvironment] = "DeployedToEnvironment"
+	tests[types.InUse] = "InUse"
+	tests[types.Referrer] = "Referrer"
+	tests[types.ReferrerSubjectDeleted] = "ReferrerSubjectDeleted"
+	tests[types.ReferrerSubjectNotFound] = "ReferrerSubjectNotFound"
+	tests[types.UnknownExpired] = "UnknownExpired"
+	tests[types.ProjectArchived] = "ProjectArchived"
+	tests[types.ProjectMissing] = "ProjectMissing"
+	tests[types.DigestChanged] = "DigestChanged"
 
 	for in, out := range tests {
 		result := in.String()
@@ -40,3 +52,19 @@ func TestTagType(t *testing.T) {
 		}
 	}
 }
+
+func TestBranchIsStaled(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	branch := &types.Branch{Name: "feature/test", LastCommitDate: now.Add(-40 * 24 * time.Hour)}
+
+	if !branch.IsStaled(now, 30) {
+		t.Fatal("branch must be staled")
+	}
+
+	// branch is staled relative to time of plan
+	if branch.IsStaled(now.Add(-20*24*time.Hour), 30) {
+		t.Fatal("branch must not be staled")
+	}
+}
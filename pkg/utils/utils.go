@@ -16,6 +16,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 const maxGitlabSluglifyLength = 63
@@ -82,3 +83,33 @@ func FilterStrings(list []string, filter string) []string {
 
 	return result
 }
+
+// Run function for every index from 0 to count with limited number of workers.
+func Parallel(workers int, count int, run func(i int)) {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+
+	items := make(chan int)
+
+	for range min(workers, count) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range items {
+				run(i)
+			}
+		}()
+	}
+
+	for i := range count {
+		items <- i
+	}
+
+	close(items)
+	wg.Wait()
+}
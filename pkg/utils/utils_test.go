@@ -13,7 +13,9 @@ limitations under the License.
 package utils_test
 
 import (
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/maksim-paskal/gitlab-registry-cleaner/pkg/utils"
 )
@@ -91,3 +93,36 @@ func TestFilterStrings(t *testing.T) {
 		t.Fatal("FilterStrings must return 3")
 	}
 }
+
+func TestParallel(t *testing.T) {
+	t.Parallel()
+
+	var (
+		running    int32
+		maxRunning int32
+		done       int32
+	)
+
+	utils.Parallel(3, 20, func(_ int) {
+		current := atomic.AddInt32(&running, 1)
+
+		for {
+			previous := atomic.LoadInt32(&maxRunning)
+			if current <= previous || atomic.CompareAndSwapInt32(&maxRunning, previous, current) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&done, 1)
+	})
+
+	if done != 20 {
+		t.Fatalf("done %d need 20", done)
+	}
+
+	if maxRunning > 3 {
+		t.Fatalf("max running %d must not be greater than 3", maxRunning)
+	}
+}